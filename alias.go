@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// AliasPrefix is the key prefix for aliases
+	AliasPrefix = "alias_"
+
+	// MaxAliasDepth is the maximum length of a chain of aliases
+	MaxAliasDepth = 10
+)
+
+// Alias is another name for a bookmark, command or alias. Aliases refer to
+// their target by name so they follow any changes to it.
+type Alias struct {
+	Name    string    `json:"name"`
+	Target  string    `json:"target"`
+	Owner   string    `json:"owner,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+func aliasKey(name string) []byte {
+	return []byte(AliasPrefix + strings.ToLower(name))
+}
+
+// LookupAlias returns the alias with the given name
+func LookupAlias(name string) (alias Alias, ok bool) {
+	name = strings.ToLower(name)
+	val, err := db.Get(aliasKey(name))
+	if err != nil {
+		if err != ErrKeyNotFound {
+			log.Printf("error looking up alias for %s: %s", name, err)
+		}
+		return
+	}
+
+	if err := json.Unmarshal(val, &alias); err != nil {
+		log.Printf("error decoding alias for %s: %s", name, err)
+		return Alias{}, false
+	}
+	return alias, true
+}
+
+// SaveAlias ...
+func SaveAlias(alias Alias) error {
+	alias.Name = strings.ToLower(alias.Name)
+	alias.Target = strings.ToLower(alias.Target)
+
+	val, err := json.Marshal(alias)
+	if err != nil {
+		return err
+	}
+	return db.Put(aliasKey(alias.Name), val)
+}
+
+// DeleteAlias ...
+func DeleteAlias(name string) error {
+	return db.Delete(aliasKey(name))
+}
+
+// ListAliases returns all aliases ordered by name
+func ListAliases() ([]Alias, error) {
+	return ListAliasesByPrefix("")
+}
+
+// ListAliasesByPrefix returns the aliases whose names start with prefix
+// ordered by name
+func ListAliasesByPrefix(prefix string) ([]Alias, error) {
+	var aliases []Alias
+
+	err := db.Scan(aliasKey(prefix), func(key []byte) error {
+		val, err := db.Get(key)
+		if err != nil {
+			return err
+		}
+		var alias Alias
+		if err := json.Unmarshal(val, &alias); err != nil {
+			return err
+		}
+		aliases = append(aliases, alias)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(aliases, func(i, j int) bool {
+		return aliases[i].Name < aliases[j].Name
+	})
+
+	return aliases, nil
+}
+
+// ResolveAlias follows the chain of aliases starting at name and returns the
+// name of the bookmark or command it ends at, or name itself if it is not an
+// alias. It is an error if the chain loops or is too long.
+func ResolveAlias(name string) (string, error) {
+	name = strings.ToLower(name)
+	seen := []string{name}
+
+	for {
+		alias, ok := LookupAlias(name)
+		if !ok {
+			return name, nil
+		}
+
+		name = alias.Target
+		for _, s := range seen {
+			if s == name {
+				return "", invalidf(
+					"alias cycle: %s -> %s", strings.Join(seen, " -> "), name,
+				)
+			}
+		}
+		seen = append(seen, name)
+
+		if len(seen) > MaxAliasDepth {
+			return "", invalidf("alias chain too long: %s", strings.Join(seen, " -> "))
+		}
+	}
+}
+
+// ValidateAlias checks that an alias called name may point at target: the
+// target must exist and the alias must not lead back to itself
+func ValidateAlias(name, target string) error {
+	name, target = strings.ToLower(name), strings.ToLower(target)
+
+	if name == target {
+		return invalidf("alias %s cannot point at itself", name)
+	}
+	if _, ok := LookupBookmark(name); ok {
+		return invalidf("invalid name %q: already a bookmark", name)
+	}
+
+	resolved := target
+	for i := 0; i < MaxAliasDepth; i++ {
+		if resolved == name {
+			return invalidf("alias %s -> %s would create a cycle", name, target)
+		}
+		alias, ok := LookupAlias(resolved)
+		if !ok {
+			break
+		}
+		resolved = alias.Target
+	}
+
+	resolved, err := ResolveAlias(target)
+	if err != nil {
+		return err
+	}
+	if _, ok := LookupBookmark(resolved); !ok && LookupCommand(resolved) == nil {
+		return invalidf("alias target %s does not exist", target)
+	}
+
+	return nil
+}
+
+// AliasesByTarget returns the names of all aliases grouped by the bookmark
+// or command they resolve to, and the aliases whose target no longer exists
+func AliasesByTarget() (map[string][]string, []Alias, error) {
+	aliases, err := ListAliases()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		groups   = make(map[string][]string)
+		dangling []Alias
+	)
+	for _, alias := range aliases {
+		target, err := ResolveAlias(alias.Name)
+		if err != nil {
+			dangling = append(dangling, alias)
+			continue
+		}
+		if _, ok := LookupBookmark(target); !ok && LookupCommand(target) == nil {
+			dangling = append(dangling, alias)
+			continue
+		}
+		groups[target] = append(groups[target], alias.Name)
+	}
+	return groups, dangling, nil
+}
+
+// AliasCommand ...
+type AliasCommand struct{}
+
+// Name ...
+func (p AliasCommand) Name() string {
+	return "alias"
+}
+
+// Desc ...
+func (p AliasCommand) Desc() string {
+	return `alias [name] [target]
+
+	Adds an alias with the given name for an existing bookmark, command or
+	alias. For example:
+
+	alias w wiki
+
+	Will make 'w foo' the same as 'wiki foo', following any later changes
+	to 'wiki'. Use remove to remove an alias.
+	`
+}
+
+// ReadOnly ...
+func (p AliasCommand) ReadOnly() bool {
+	return false
+}
+
+// Preview ...
+func (p AliasCommand) Preview(args []string) string {
+	if len(args) != 2 {
+		return ""
+	}
+	if alias, ok := LookupAlias(args[0]); ok {
+		return fmt.Sprintf(
+			"Change alias %s (currently for %s) to %s",
+			args[0], alias.Target, args[1],
+		)
+	}
+	return fmt.Sprintf("Add alias %s for %s", args[0], args[1])
+}
+
+// Complete ...
+func (p AliasCommand) Complete(args []string) []string {
+	if len(args) != 2 {
+		return nil
+	}
+	return append(CompleteBookmarkNames(args[1], true), CompleteCommandNames(args[1])...)
+}
+
+// Exec ...
+func (p AliasCommand) Exec(w http.ResponseWriter, r *http.Request, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("expected 2 arguments got %d", len(args))
+	}
+	name, target := args[0], args[1]
+
+	alias, ok := LookupAlias(name)
+	if ok {
+		if err := AuthorizeModify(r, Bookmark{name: alias.Name, owner: alias.Owner}); err != nil {
+			return err
+		}
+	} else {
+		if err := ValidateName(name); err != nil {
+			return err
+		}
+		owner, err := AuthorizeCreate(r)
+		if err != nil {
+			return err
+		}
+		alias = Alias{Name: name, Owner: owner, Created: time.Now()}
+	}
+
+	if err := ValidateAlias(name, target); err != nil {
+		return err
+	}
+	alias.Target = target
+
+	if err := SaveAlias(alias); err != nil {
+		log.Printf("put key failed: %s", err)
+		return err
+	}
+
+	w.Write([]byte("OK"))
+
+	return nil
+}
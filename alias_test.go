@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveAlias(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	assert.Nil(SaveBookmark(NewBookmark("wiki", "https://wiki.example.com/")))
+	assert.Nil(SaveAlias(Alias{Name: "w", Target: "wiki"}))
+	assert.Nil(SaveAlias(Alias{Name: "WW", Target: "W"}))
+
+	name, err := ResolveAlias("ww")
+	assert.Nil(err)
+	assert.Equal("wiki", name)
+
+	name, err = ResolveAlias("wiki")
+	assert.Nil(err)
+	assert.Equal("wiki", name)
+
+	name, err = ResolveAlias("asdf")
+	assert.Nil(err)
+	assert.Equal("asdf", name)
+
+	// Cycles are detected
+	assert.Nil(SaveAlias(Alias{Name: "a", Target: "b"}))
+	assert.Nil(SaveAlias(Alias{Name: "b", Target: "a"}))
+	_, err = ResolveAlias("a")
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "alias cycle: a -> b -> a")
+}
+
+func TestValidateAlias(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	assert.Nil(SaveBookmark(NewBookmark("wiki", "https://wiki.example.com/")))
+	assert.Nil(SaveAlias(Alias{Name: "w", Target: "wiki"}))
+
+	assert.Nil(ValidateAlias("ww", "w"))
+	assert.Nil(ValidateAlias("p", "ping"))
+
+	assert.NotNil(ValidateAlias("w", "w"))
+	assert.NotNil(ValidateAlias("x", "asdf"))
+	assert.NotNil(ValidateAlias("wiki", "ping"))
+
+	assert.Nil(SaveAlias(Alias{Name: "ww", Target: "w"}))
+	err := ValidateAlias("w", "ww")
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "cycle")
+
+	// Aliases and bookmarks share names
+	assert.NotNil(ValidateName("w"))
+}
+
+func TestAliasCommand(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	s := newTestServer(t, Config{})
+
+	assert.Equal("OK", runCommand(s, "add wiki https://wiki.example.com/?q={query}").Body.String())
+	assert.Equal("OK", runCommand(s, "alias w wiki").Body.String())
+	assert.Equal("OK", runCommand(s, "alias confluence w").Body.String())
+	assert.Equal("OK", runCommand(s, "alias p ping").Body.String())
+
+	assert.Equal(http.StatusBadRequest, runCommand(s, "alias x asdf").Code)
+	assert.Equal(http.StatusBadRequest, runCommand(s, "alias w confluence").Code)
+	assert.Equal(http.StatusBadRequest, runCommand(s, "alias list wiki").Code)
+	assert.Equal(http.StatusBadRequest, runCommand(s, "add w https://example.com/").Code)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		s.router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/?q=confluence+foo")
+	assert.Equal(http.StatusFound, w.Code)
+	assert.Equal("https://wiki.example.com/?q=foo", w.Header().Get("Location"))
+
+	w = get("/p")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), "pong")
+
+	// Aliases follow changes to their target
+	assert.Equal("OK", runCommand(s, "add wiki https://en.wikipedia.org/wiki/{1}").Body.String())
+	w = get("/w/Go")
+	assert.Equal("https://en.wikipedia.org/wiki/Go", w.Header().Get("Location"))
+
+	bookmark, _ := LookupBookmark("wiki")
+	assert.Equal(int64(2), bookmark.Hits())
+
+	w = get("/list")
+	assert.Contains(w.Body.String(), "also <code>confluence</code> <code>w</code>")
+	assert.Contains(w.Body.String(), "also <code>p</code>")
+
+	// Removing the target leaves dangling aliases shown on the list page
+	assert.Equal("OK", runCommand(s, "remove wiki").Body.String())
+	w = get("/list")
+	assert.Contains(w.Body.String(), "Aliases for missing bookmarks")
+
+	assert.Equal("OK", runCommand(s, "remove w").Body.String())
+	_, ok := LookupAlias("w")
+	assert.False(ok)
+}
+
+func TestAliasAuth(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	s, cleanup := newAuthServer(t)
+	defer cleanup()
+
+	assert.Nil(SaveBookmark(NewBookmark("wiki", "https://wiki.example.com/")))
+
+	w := commandAuthRequest(s, "alias w wiki", bearer("alicetoken"))
+	assert.Equal("OK", w.Body.String())
+
+	alias, ok := LookupAlias("w")
+	assert.True(ok)
+	assert.Equal("alice", alias.Owner)
+	assert.False(alias.Created.After(time.Now()))
+
+	w = commandAuthRequest(s, "remove w", bearer("bobtoken"))
+	assert.Equal(http.StatusForbidden, w.Code)
+
+	w = commandAuthRequest(s, "alias w ping", bearer("bobtoken"))
+	assert.Equal(http.StatusForbidden, w.Code)
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// DefaultAPILimit is the default page size for API listings
+	DefaultAPILimit = 100
+	// MaxAPILimit is the maximum page size for API listings
+	MaxAPILimit = 1000
+)
+
+// BookmarkRequest is the body accepted when creating or updating a bookmark
+type BookmarkRequest struct {
+	Name string   `json:"name"`
+	URL  string   `json:"url"`
+	Desc string   `json:"desc"`
+	Tags []string `json:"tags"`
+
+	Completions     []string `json:"completions"`
+	CompletionsFile string   `json:"completions_file"`
+
+	SuggestURL    string `json:"suggest_url"`
+	SuggestFormat string `json:"suggest_format"`
+}
+
+// BookmarkList is the response body of a bookmark listing
+type BookmarkList struct {
+	Bookmarks []Bookmark `json:"bookmarks"`
+	Total     int        `json:"total"`
+	Offset    int        `json:"offset"`
+	Limit     int        `json:"limit"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("error encoding json response: %s", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeAuthError(w http.ResponseWriter, err error) {
+	writeJSONError(w, ErrorStatus(err), err.Error())
+}
+
+// readBookmarkRequest decodes the request body. Only JSON bodies are
+// accepted so that other sites cannot make changes with simple form posts.
+func readBookmarkRequest(r *http.Request) (req BookmarkRequest, err error) {
+	defer r.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		err = errors.New("content type must be application/json")
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+	return
+}
+
+func matchTags(tags []string, q string) bool {
+	for _, tag := range tags {
+		if strings.Contains(strings.ToLower(tag), q) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchBookmark reports whether the bookmark matches the search term q
+// (against name, url, description and tags) and has all of the given tags
+func MatchBookmark(bookmark Bookmark, q string, tags []string) bool {
+	if q != "" {
+		q = strings.ToLower(q)
+		if !strings.Contains(strings.ToLower(bookmark.name), q) &&
+			!strings.Contains(strings.ToLower(bookmark.url), q) &&
+			!strings.Contains(strings.ToLower(bookmark.desc), q) &&
+			!matchTags(bookmark.tags, q) {
+			return false
+		}
+	}
+
+	for _, tag := range tags {
+		found := false
+		for _, t := range bookmark.tags {
+			if strings.EqualFold(t, tag) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// APIListBookmarksHandler ...
+func (s *Server) APIListBookmarksHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		s.counters.Inc("n_api_list")
+
+		query := r.URL.Query()
+		q := query.Get("q")
+		tags := query["tag"]
+
+		offset := SafeParseInt(query.Get("offset"), 0)
+		if offset < 0 {
+			offset = 0
+		}
+		limit := SafeParseInt(query.Get("limit"), DefaultAPILimit)
+		if limit <= 0 || limit > MaxAPILimit {
+			limit = DefaultAPILimit
+		}
+
+		bookmarks, err := ListBookmarks()
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		matched := FilterBookmarks(bookmarks, q, tags)
+		if order := query.Get("sort"); order != "" {
+			SortBookmarks(matched, order)
+		}
+
+		total := len(matched)
+		if offset > total {
+			offset = total
+		}
+		end := offset + limit
+		if end > total {
+			end = total
+		}
+
+		writeJSON(w, http.StatusOK, BookmarkList{
+			Bookmarks: matched[offset:end],
+			Total:     total,
+			Offset:    offset,
+			Limit:     limit,
+		})
+	}
+}
+
+// APIGetBookmarkHandler ...
+func (s *Server) APIGetBookmarkHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		s.counters.Inc("n_api_get")
+
+		bookmark, ok := LookupBookmark(p.ByName("name"))
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "bookmark not found")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, bookmark)
+	}
+}
+
+// APICreateBookmarkHandler ...
+func (s *Server) APICreateBookmarkHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		s.counters.Inc("n_api_create")
+
+		owner, err := AuthorizeCreate(r)
+		if err != nil {
+			writeAuthError(w, err)
+			return
+		}
+
+		req, err := readBookmarkRequest(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+
+		name := strings.ToLower(req.Name)
+		if err := ValidateName(name); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := ValidateURL(req.URL); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := ValidateCompletionsFile(req.CompletionsFile); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := ValidateSuggestURL(req.SuggestURL); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := ValidateSuggestFormat(req.SuggestFormat); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := s.AuthorizeSuggestURL(r, req.SuggestURL); err != nil {
+			writeJSONError(w, http.StatusForbidden, err.Error())
+			return
+		}
+
+		if _, ok := LookupBookmark(name); ok {
+			writeJSONError(w, http.StatusConflict, "bookmark already exists")
+			return
+		}
+
+		bookmark := NewBookmark(name, req.URL)
+		bookmark.owner = owner
+		bookmark.desc = req.Desc
+		bookmark.tags = req.Tags
+		bookmark.completions = req.Completions
+		bookmark.completionsFile = req.CompletionsFile
+		bookmark.suggestURL = req.SuggestURL
+		bookmark.suggestFormat = req.SuggestFormat
+
+		if err := SaveBookmarkBy(RequestUserName(r), bookmark); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		w.Header().Set("Location", "/api/v1/bookmarks/"+name)
+		writeJSON(w, http.StatusCreated, bookmark)
+	}
+}
+
+// APIUpdateBookmarkHandler ...
+func (s *Server) APIUpdateBookmarkHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		s.counters.Inc("n_api_update")
+
+		bookmark, ok := LookupBookmark(p.ByName("name"))
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "bookmark not found")
+			return
+		}
+		if err := AuthorizeModify(r, bookmark); err != nil {
+			writeAuthError(w, err)
+			return
+		}
+
+		req, err := readBookmarkRequest(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+		if req.Name != "" && !strings.EqualFold(req.Name, bookmark.name) {
+			writeJSONError(w, http.StatusBadRequest, "bookmark name cannot be changed")
+			return
+		}
+		if err := ValidateURL(req.URL); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := ValidateCompletionsFile(req.CompletionsFile); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := ValidateSuggestURL(req.SuggestURL); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := ValidateSuggestFormat(req.SuggestFormat); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.SuggestURL != bookmark.suggestURL {
+			if err := s.AuthorizeSuggestURL(r, req.SuggestURL); err != nil {
+				writeJSONError(w, http.StatusForbidden, err.Error())
+				return
+			}
+		}
+
+		bookmark.url = req.URL
+		bookmark.desc = req.Desc
+		bookmark.tags = req.Tags
+		bookmark.completions = req.Completions
+		bookmark.completionsFile = req.CompletionsFile
+		bookmark.suggestURL = req.SuggestURL
+		bookmark.suggestFormat = req.SuggestFormat
+		bookmark.updated = time.Now()
+
+		if err := SaveBookmarkBy(RequestUserName(r), bookmark); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, bookmark)
+	}
+}
+
+// APIDeleteBookmarkHandler ...
+func (s *Server) APIDeleteBookmarkHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		s.counters.Inc("n_api_delete")
+
+		bookmark, ok := LookupBookmark(p.ByName("name"))
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "bookmark not found")
+			return
+		}
+		if err := AuthorizeModify(r, bookmark); err != nil {
+			writeAuthError(w, err)
+			return
+		}
+
+		if err := DeleteBookmarkBy(RequestUserName(r), bookmark.name); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
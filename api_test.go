@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func apiRequest(s *Server, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	s.router.ServeHTTP(w, r)
+	return w
+}
+
+func TestAPICreateGetBookmark(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	s := newTestServer(t, Config{})
+
+	w := apiRequest(s, "POST", "/api/v1/bookmarks",
+		`{"name":"ddg","url":"https://duckduckgo.com/?q=%s","desc":"DuckDuckGo","tags":["search"]}`)
+	assert.Equal(http.StatusCreated, w.Code)
+	assert.Equal("/api/v1/bookmarks/ddg", w.Header().Get("Location"))
+
+	w = apiRequest(s, "POST", "/api/v1/bookmarks",
+		`{"name":"ddg","url":"https://duckduckgo.com/"}`)
+	assert.Equal(http.StatusConflict, w.Code)
+
+	w = apiRequest(s, "GET", "/api/v1/bookmarks/ddg", "")
+	assert.Equal(http.StatusOK, w.Code)
+
+	var bookmark Bookmark
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &bookmark))
+	assert.Equal("ddg", bookmark.Name())
+	assert.Equal("https://duckduckgo.com/?q=%s", bookmark.URL())
+	assert.Equal("DuckDuckGo", bookmark.Desc())
+	assert.Equal([]string{"search"}, bookmark.Tags())
+
+	w = apiRequest(s, "GET", "/api/v1/bookmarks/asdf", "")
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestAPICreateInvalidBookmark(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	s := newTestServer(t, Config{})
+
+	w := apiRequest(s, "POST", "/api/v1/bookmarks", `{"name":"foo","url":"not a url"}`)
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	w = apiRequest(s, "POST", "/api/v1/bookmarks", `{"name":"","url":"https://example.com"}`)
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	w = apiRequest(s, "POST", "/api/v1/bookmarks", `{`)
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/api/v1/bookmarks",
+		strings.NewReader(`{"name":"foo","url":"https://example.com"}`))
+	r.Header.Set("Content-Type", "text/plain")
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusBadRequest, w.Code)
+}
+
+func TestAPIUpdateDeleteBookmark(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	s := newTestServer(t, Config{})
+
+	w := apiRequest(s, "PUT", "/api/v1/bookmarks/foo", `{"url":"https://example.com"}`)
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	assert.Nil(SaveBookmark(NewBookmark("foo", "https://example.com")))
+
+	w = apiRequest(s, "PUT", "/api/v1/bookmarks/foo", `{"url":"://"}`)
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	w = apiRequest(s, "PUT", "/api/v1/bookmarks/foo", `{"url":"https://example.org","desc":"Example"}`)
+	assert.Equal(http.StatusOK, w.Code)
+
+	bookmark, ok := LookupBookmark("foo")
+	assert.True(ok)
+	assert.Equal("https://example.org", bookmark.URL())
+	assert.Equal("Example", bookmark.Desc())
+
+	w = apiRequest(s, "DELETE", "/api/v1/bookmarks/foo", "")
+	assert.Equal(http.StatusNoContent, w.Code)
+
+	_, ok = LookupBookmark("foo")
+	assert.False(ok)
+
+	w = apiRequest(s, "DELETE", "/api/v1/bookmarks/foo", "")
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestAPIListBookmarks(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	assert.Nil(EnsureDefaultBookmarks())
+
+	bookmark := NewBookmark("wiki", "https://wiki.example.com/?q=%s")
+	bookmark.tags = []string{"internal"}
+	assert.Nil(SaveBookmark(bookmark))
+
+	s := newTestServer(t, Config{})
+
+	var list BookmarkList
+
+	w := apiRequest(s, "GET", "/api/v1/bookmarks", "")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(len(DefaultBookmarks)+1, list.Total)
+	assert.Len(list.Bookmarks, list.Total)
+
+	w = apiRequest(s, "GET", "/api/v1/bookmarks?limit=2&offset=1", "")
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Len(list.Bookmarks, 2)
+	assert.Equal(1, list.Offset)
+
+	w = apiRequest(s, "GET", "/api/v1/bookmarks?q=google", "")
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &list))
+	for _, bookmark := range list.Bookmarks {
+		assert.Contains(bookmark.URL(), "google")
+	}
+
+	w = apiRequest(s, "GET", "/api/v1/bookmarks?tag=internal", "")
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(1, list.Total)
+	assert.Equal("wiki", list.Bookmarks[0].Name())
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrUnauthorized is returned when an action requires an authenticated user
+	ErrUnauthorized = errors.New("authentication required")
+
+	// ErrForbidden is returned when the user may not perform an action
+	ErrForbidden = errors.New("permission denied")
+)
+
+type authContextKey struct{}
+
+// User is an authenticated user
+type User struct {
+	Name  string
+	Admin bool
+}
+
+// Authenticator identifies the user making a request. It returns a nil User
+// if the request carries no credentials it understands and an error if the
+// credentials are invalid.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*User, error)
+}
+
+// TokenAuthenticator authenticates static API tokens passed as
+// "Authorization: Bearer <token>"
+type TokenAuthenticator struct {
+	tokens map[string]string
+}
+
+// NewTokenAuthenticator loads tokens from a file with one "<token> <user>"
+// pair per line
+func NewTokenAuthenticator(path string) (*TokenAuthenticator, error) {
+	tokens := make(map[string]string)
+	err := readAuthFile(path, func(fields []string) error {
+		if len(fields) != 2 {
+			return errors.New("expected <token> <user>")
+		}
+		tokens[fields[0]] = fields[1]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &TokenAuthenticator{tokens: tokens}, nil
+}
+
+// Authenticate ...
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (*User, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, nil
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+
+	for t, name := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return &User{Name: name}, nil
+		}
+	}
+	return nil, ErrUnauthorized
+}
+
+// BasicAuthenticator authenticates HTTP basic auth against an htpasswd file.
+// Only bcrypt (htpasswd -B) and SHA1 (htpasswd -s) hashes are supported.
+type BasicAuthenticator struct {
+	users map[string]string
+}
+
+// NewBasicAuthenticator ...
+func NewBasicAuthenticator(path string) (*BasicAuthenticator, error) {
+	users := make(map[string]string)
+	err := readAuthFile(path, func(fields []string) error {
+		if len(fields) != 1 || !strings.Contains(fields[0], ":") {
+			return errors.New("expected <user>:<hash>")
+		}
+		parts := strings.SplitN(fields[0], ":", 2)
+		if !strings.HasPrefix(parts[1], "$2") && !strings.HasPrefix(parts[1], "{SHA}") {
+			return fmt.Errorf("unsupported hash for %s (use bcrypt or SHA1)", parts[0])
+		}
+		users[parts[0]] = parts[1]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BasicAuthenticator{users: users}, nil
+}
+
+// Authenticate ...
+func (a *BasicAuthenticator) Authenticate(r *http.Request) (*User, error) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+
+	hash, ok := a.users[name]
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+
+	if strings.HasPrefix(hash, "{SHA}") {
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) != 1 {
+			return nil, ErrUnauthorized
+		}
+	} else if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return nil, ErrUnauthorized
+	}
+
+	return &User{Name: name}, nil
+}
+
+// ProxyAuthenticator trusts a header such as X-Forwarded-User set by a
+// reverse proxy, but only on requests from trusted networks
+type ProxyAuthenticator struct {
+	header  string
+	trusted []*net.IPNet
+}
+
+// NewProxyAuthenticator ...
+func NewProxyAuthenticator(header string, trusted []string) (*ProxyAuthenticator, error) {
+	networks, err := ParseNetworks(trusted)
+	if err != nil {
+		return nil, err
+	}
+	return &ProxyAuthenticator{header: header, trusted: networks}, nil
+}
+
+// ParseNetworks parses networks in CIDR notation, skipping blank ones
+func ParseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// containsIP reports whether ip is in any of the networks
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP returns the IP address the request came from, nil if unknown
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// Authenticate ...
+func (a *ProxyAuthenticator) Authenticate(r *http.Request) (*User, error) {
+	name := r.Header.Get(a.header)
+	if name == "" {
+		return nil, nil
+	}
+
+	if containsIP(a.trusted, remoteIP(r)) {
+		return &User{Name: name}, nil
+	}
+
+	// Ignore the header from untrusted clients rather than failing
+	return nil, nil
+}
+
+func readAuthFile(path string, f func(fields []string) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := f(strings.Fields(line)); err != nil {
+			return fmt.Errorf("%s:%d: %s", path, n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// Auth authenticates requests and decides what users may do
+type Auth struct {
+	authenticators []Authenticator
+	admins         map[string]bool
+	required       bool
+	basic          bool
+}
+
+// NewAuth configures authentication from config. If no authentication
+// methods are configured anyone may do anything.
+func NewAuth(config Config) (*Auth, error) {
+	auth := &Auth{
+		admins:   make(map[string]bool),
+		required: config.AuthRequired,
+	}
+
+	if config.AuthTokens != "" {
+		a, err := NewTokenAuthenticator(config.AuthTokens)
+		if err != nil {
+			return nil, err
+		}
+		auth.authenticators = append(auth.authenticators, a)
+	}
+
+	if config.AuthHtpasswd != "" {
+		a, err := NewBasicAuthenticator(config.AuthHtpasswd)
+		if err != nil {
+			return nil, err
+		}
+		auth.authenticators = append(auth.authenticators, a)
+		auth.basic = true
+	}
+
+	if config.AuthProxyHeader != "" {
+		a, err := NewProxyAuthenticator(config.AuthProxyHeader, config.AuthProxyTrusted)
+		if err != nil {
+			return nil, err
+		}
+		auth.authenticators = append(auth.authenticators, a)
+	}
+
+	for _, name := range config.Admins {
+		if name = strings.TrimSpace(name); name != "" {
+			auth.admins[name] = true
+		}
+	}
+
+	return auth, nil
+}
+
+// Enabled reports whether any authentication methods are configured
+func (a *Auth) Enabled() bool {
+	return len(a.authenticators) > 0
+}
+
+// Authenticate returns the user making the request, if any
+func (a *Auth) Authenticate(r *http.Request) (*User, error) {
+	for _, authenticator := range a.authenticators {
+		user, err := authenticator.Authenticate(r)
+		if err != nil {
+			return nil, err
+		}
+		if user != nil {
+			user.Admin = a.admins[user.Name]
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+// Challenge responds with 401 Unauthorized, prompting browsers for
+// credentials if basic auth is configured
+func (a *Auth) Challenge(w http.ResponseWriter, msg string) {
+	if a.basic {
+		w.Header().Set("WWW-Authenticate", `Basic realm="golinks"`)
+	}
+	http.Error(w, msg, http.StatusUnauthorized)
+}
+
+// Handler authenticates requests and records the user in the request
+// context for later authorization
+func (a *Auth) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := a.Authenticate(r)
+		if err != nil {
+			a.Challenge(w, "Invalid credentials")
+			return
+		}
+		if user == nil && a.required {
+			a.Challenge(w, "Authentication required")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), authContextKey{}, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequestUser returns the authenticated user of a request and whether
+// authentication is enabled at all
+func RequestUser(r *http.Request) (user *User, enabled bool) {
+	user, enabled = r.Context().Value(authContextKey{}).(*User)
+	return
+}
+
+// AuthorizeCreate checks that the request may create bookmarks and returns
+// the owner new bookmarks should have
+func AuthorizeCreate(r *http.Request) (string, error) {
+	user, enabled := RequestUser(r)
+	if !enabled {
+		return "", nil
+	}
+	if user == nil {
+		return "", ErrUnauthorized
+	}
+	return user.Name, nil
+}
+
+// AuthorizeModify checks that the request may modify or delete the bookmark.
+// Only the owner or an admin may modify a bookmark, bookmarks without an
+// owner may be modified by any authenticated user.
+func AuthorizeModify(r *http.Request, bookmark Bookmark) error {
+	user, enabled := RequestUser(r)
+	if !enabled {
+		return nil
+	}
+	if user == nil {
+		return ErrUnauthorized
+	}
+	if user.Admin || bookmark.owner == "" || bookmark.owner == user.Name {
+		return nil
+	}
+	return ErrForbidden
+}
+
+// ErrorStatus maps an error to an HTTP status code
+func ErrorStatus(err error) int {
+	if _, ok := err.(*ValidationError); ok {
+		return http.StatusBadRequest
+	}
+
+	switch err {
+	case ErrUnauthorized:
+		return http.StatusUnauthorized
+	case ErrForbidden:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
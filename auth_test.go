@@ -0,0 +1,250 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeAuthFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func newAuthServer(t *testing.T) (*Server, func()) {
+	dir, err := ioutil.TempDir("", "golinks")
+	assert.Nil(t, err)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.Nil(t, err)
+
+	config := Config{
+		AuthTokens:       writeAuthFile(t, dir, "tokens", "# tokens\nalicetoken alice\nbobtoken bob\n"),
+		AuthHtpasswd:     writeAuthFile(t, dir, "htpasswd", "carol:"+string(hash)+"\n"),
+		AuthProxyHeader:  "X-Forwarded-User",
+		AuthProxyTrusted: []string{"10.0.0.0/8"},
+		Admins:           []string{"root"},
+	}
+
+	return newTestServer(t, config), func() { os.RemoveAll(dir) }
+}
+
+func authRequest(s *Server, method, path, body string, f func(r *http.Request)) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.RemoteAddr = "192.168.0.1:1234"
+	if f != nil {
+		f(r)
+	}
+	s.auth.Handler(s.router).ServeHTTP(w, r)
+	return w
+}
+
+func commandAuthRequest(s *Server, q string, f func(r *http.Request)) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := newCommandRequest(q)
+	r.RemoteAddr = "192.168.0.1:1234"
+	if f != nil {
+		f(r)
+	}
+	s.auth.Handler(s.router).ServeHTTP(w, r)
+	return w
+}
+
+func bearer(token string) func(r *http.Request) {
+	return func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+func TestAuthenticators(t *testing.T) {
+	assert := assert.New(t)
+
+	s, cleanup := newAuthServer(t)
+	defer cleanup()
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.168.0.1:1234"
+
+	user, err := s.auth.Authenticate(r)
+	assert.Nil(err)
+	assert.Nil(user)
+
+	r.Header.Set("Authorization", "Bearer alicetoken")
+	user, err = s.auth.Authenticate(r)
+	assert.Nil(err)
+	assert.Equal("alice", user.Name)
+
+	r.Header.Set("Authorization", "Bearer asdf")
+	_, err = s.auth.Authenticate(r)
+	assert.Equal(ErrUnauthorized, err)
+
+	r.Header.Del("Authorization")
+	r.SetBasicAuth("carol", "secret")
+	user, err = s.auth.Authenticate(r)
+	assert.Nil(err)
+	assert.Equal("carol", user.Name)
+
+	r.SetBasicAuth("carol", "wrong")
+	_, err = s.auth.Authenticate(r)
+	assert.Equal(ErrUnauthorized, err)
+
+	r.Header.Del("Authorization")
+	r.Header.Set("X-Forwarded-User", "root")
+	user, err = s.auth.Authenticate(r)
+	assert.Nil(err)
+	assert.Nil(user)
+
+	r.RemoteAddr = "10.1.2.3:1234"
+	user, err = s.auth.Authenticate(r)
+	assert.Nil(err)
+	assert.Equal("root", user.Name)
+	assert.True(user.Admin)
+}
+
+func TestAuthInvalidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinks")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = NewAuth(Config{AuthHtpasswd: writeAuthFile(t, dir, "htpasswd", "dave:$apr1$foo$bar\n")})
+	assert.NotNil(t, err)
+
+	_, err = NewAuth(Config{AuthTokens: filepath.Join(dir, "missing")})
+	assert.NotNil(t, err)
+}
+
+func TestAuthPermissions(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	s, cleanup := newAuthServer(t)
+	defer cleanup()
+
+	// Anonymous lookups are allowed but changes are not
+	w := commandAuthRequest(s, "add foo https://example.com", nil)
+	assert.Equal(http.StatusUnauthorized, w.Code)
+	assert.NotEmpty(w.Header().Get("WWW-Authenticate"))
+
+	w = commandAuthRequest(s, "add foo https://example.com", bearer("alicetoken"))
+	assert.Equal(http.StatusOK, w.Code)
+
+	bookmark, ok := LookupBookmark("foo")
+	assert.True(ok)
+	assert.Equal("alice", bookmark.Owner())
+
+	w = authRequest(s, "GET", "/?q=foo", "", nil)
+	assert.Equal(http.StatusFound, w.Code)
+
+	// Only the owner or an admin may change it
+	w = commandAuthRequest(s, "remove foo", bearer("bobtoken"))
+	assert.Equal(http.StatusForbidden, w.Code)
+
+	w = authRequest(s, "PUT", "/api/v1/bookmarks/foo", `{"url":"https://example.org"}`, bearer("bobtoken"))
+	assert.Equal(http.StatusForbidden, w.Code)
+
+	w = authRequest(s, "PUT", "/api/v1/bookmarks/foo", `{"url":"https://example.org"}`, bearer("alicetoken"))
+	assert.Equal(http.StatusOK, w.Code)
+
+	w = authRequest(s, "DELETE", "/api/v1/bookmarks/foo", "", func(r *http.Request) {
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("X-Forwarded-User", "root")
+	})
+	assert.Equal(http.StatusNoContent, w.Code)
+
+	w = authRequest(s, "POST", "/api/v1/bookmarks", `{"name":"bar","url":"https://example.com"}`, nil)
+	assert.Equal(http.StatusUnauthorized, w.Code)
+}
+
+func TestAuthSuggestURL(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	s, cleanup := newAuthServer(t)
+	defer cleanup()
+	s.config.SuggestHosts = []string{"wikipedia.org"}
+
+	asRoot := func(r *http.Request) {
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("X-Forwarded-User", "root")
+	}
+
+	// Users may only fetch suggestions from the allowed hosts
+	w := authRequest(s, "POST", "/api/v1/bookmarks",
+		`{"name":"foo","url":"https://example.com","suggest_url":"https://internal.example.com/?q={query}"}`,
+		bearer("alicetoken"))
+	assert.Equal(http.StatusForbidden, w.Code)
+
+	w = authRequest(s, "POST", "/api/v1/bookmarks",
+		`{"name":"wp","url":"https://example.com","suggest_url":"https://en.wikipedia.org/w/api.php?search={query}"}`,
+		bearer("alicetoken"))
+	assert.Equal(http.StatusCreated, w.Code)
+
+	// Admins from any host, but never local or private addresses
+	w = authRequest(s, "POST", "/api/v1/bookmarks",
+		`{"name":"foo","url":"https://example.com","suggest_url":"https://internal.example.com/?q={query}"}`,
+		asRoot)
+	assert.Equal(http.StatusCreated, w.Code)
+
+	w = authRequest(s, "POST", "/api/v1/bookmarks",
+		`{"name":"meta","url":"https://example.com","suggest_url":"http://169.254.169.254/latest/{query}"}`,
+		asRoot)
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	// Others may change a bookmark without changing its service
+	bookmark, ok := LookupBookmark("foo")
+	assert.True(ok)
+	bookmark.owner = ""
+	assert.Nil(SaveBookmark(bookmark))
+
+	w = authRequest(s, "PUT", "/api/v1/bookmarks/foo",
+		`{"url":"https://example.org","suggest_url":"https://internal.example.com/?q={query}"}`,
+		bearer("bobtoken"))
+	assert.Equal(http.StatusOK, w.Code)
+
+	w = authRequest(s, "PUT", "/api/v1/bookmarks/foo",
+		`{"url":"https://example.org","suggest_url":"https://internal.example.com/admin?q={query}"}`,
+		bearer("bobtoken"))
+	assert.Equal(http.StatusForbidden, w.Code)
+
+	// Or import one
+	w = authRequest(s, "POST", "/import?format=json",
+		`[{"name":"bar","url":"https://example.com","suggest_url":"https://internal.example.com/?q={query}"}]`,
+		bearer("alicetoken"))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), `"status":"forbidden"`)
+	_, ok = LookupBookmark("bar")
+	assert.False(ok)
+}
+
+func TestAuthRequired(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	s := newTestServer(t, Config{})
+	s.auth.required = true
+	s.auth.authenticators = []Authenticator{&TokenAuthenticator{
+		tokens: map[string]string{"alicetoken": "alice"},
+	}}
+
+	w := authRequest(s, "GET", "/list", "", nil)
+	assert.Equal(http.StatusUnauthorized, w.Code)
+
+	w = authRequest(s, "GET", "/list", "", bearer("alicetoken"))
+	assert.Equal(http.StatusOK, w.Code)
+}
@@ -1,18 +1,75 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
-
-	"github.com/prologic/bitcask"
+	"time"
 )
 
+// BookmarkVersion is the current version of the encoded bookmark record
+const BookmarkVersion = 1
+
+// BookmarkPrefix is the key prefix under which bookmarks are stored
+const BookmarkPrefix = "bookmark_"
+
 // Bookmark ...
 type Bookmark struct {
-	name string
-	url  string
+	name    string
+	url     string
+	desc    string
+	tags    []string
+	owner   string
+	created time.Time
+	updated time.Time
+
+	// hits is the count of the bookmark's usage record, it is not stored
+	// with the bookmark
+	hits int64
+
+	// Values to complete the arguments with in search suggestions
+	completions     []string
+	completionsFile string
+
+	// Service to fetch search suggestions for the arguments from
+	suggestURL    string
+	suggestFormat string
+}
+
+// bookmarkRecord is the versioned representation of a Bookmark as stored
+// in the database.
+type bookmarkRecord struct {
+	Version int       `json:"v"`
+	Name    string    `json:"name"`
+	URL     string    `json:"url"`
+	Desc    string    `json:"desc,omitempty"`
+	Tags    []string  `json:"tags,omitempty"`
+	Owner   string    `json:"owner,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+	Hits    int64     `json:"hits"`
+
+	Completions     []string `json:"completions,omitempty"`
+	CompletionsFile string   `json:"completions_file,omitempty"`
+
+	SuggestURL    string `json:"suggest_url,omitempty"`
+	SuggestFormat string `json:"suggest_format,omitempty"`
+}
+
+// NewBookmark ...
+func NewBookmark(name, url string) Bookmark {
+	now := time.Now()
+	return Bookmark{
+		name:    name,
+		url:     url,
+		created: now,
+		updated: now,
+	}
 }
 
 // Name ...
@@ -25,29 +82,413 @@ func (b Bookmark) URL() string {
 	return b.url
 }
 
+// Desc ...
+func (b Bookmark) Desc() string {
+	return b.desc
+}
+
+// Tags ...
+func (b Bookmark) Tags() []string {
+	return b.tags
+}
+
+// Completions returns the values to complete the arguments of the bookmark
+// with, see also CompletionsFile
+func (b Bookmark) Completions() []string {
+	return b.completions
+}
+
+// CompletionsFile returns the name of the file in the completions directory
+// with more values to complete the arguments of the bookmark with
+func (b Bookmark) CompletionsFile() string {
+	return b.completionsFile
+}
+
+// SuggestURL returns the URL of the service to fetch search suggestions for
+// the arguments of the bookmark from, a URL template like the bookmark's own
+func (b Bookmark) SuggestURL() string {
+	return b.suggestURL
+}
+
+// SuggestFormat returns the response format of the suggest URL, see
+// ParseSuggestions
+func (b Bookmark) SuggestFormat() string {
+	return b.suggestFormat
+}
+
+// Owner ...
+func (b Bookmark) Owner() string {
+	return b.owner
+}
+
+// Created ...
+func (b Bookmark) Created() time.Time {
+	return b.created
+}
+
+// Updated ...
+func (b Bookmark) Updated() time.Time {
+	return b.updated
+}
+
+// Hits returns the number of times the bookmark was used, from its usage
+// record as of when it was looked up
+func (b Bookmark) Hits() int64 {
+	return b.hits
+}
+
 // Exec ...
-func (b Bookmark) Exec(w http.ResponseWriter, r *http.Request, q string) {
-	url := b.url
-	if q != "" {
-		url = fmt.Sprintf(b.url, q)
+func (b Bookmark) Exec(w http.ResponseWriter, r *http.Request, q string) error {
+	t, err := ParseURLTemplate(b.url)
+	if err != nil {
+		return err
+	}
+
+	url, err := t.Expand(q)
+	if err != nil {
+		return err
 	}
+
 	http.Redirect(w, r, url, http.StatusFound)
+	return nil
+}
+
+// MarshalJSON encodes the bookmark as a versioned record
+func (b Bookmark) MarshalJSON() ([]byte, error) {
+	return json.Marshal(bookmarkRecord{
+		Version: BookmarkVersion,
+		Name:    b.name,
+		URL:     b.url,
+		Desc:    b.desc,
+		Tags:    b.tags,
+		Owner:   b.owner,
+		Created: b.created,
+		Updated: b.updated,
+		Hits:    b.hits,
+
+		Completions:     b.completions,
+		CompletionsFile: b.completionsFile,
+
+		SuggestURL:    b.suggestURL,
+		SuggestFormat: b.suggestFormat,
+	})
+}
+
+// UnmarshalJSON decodes a versioned bookmark record
+func (b *Bookmark) UnmarshalJSON(data []byte) error {
+	var record bookmarkRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	if record.Version > BookmarkVersion {
+		return fmt.Errorf("unsupported bookmark version %d", record.Version)
+	}
+
+	b.name = record.Name
+	b.url = record.URL
+	b.desc = record.Desc
+	b.tags = record.Tags
+	b.owner = record.Owner
+	b.created = record.Created
+	b.updated = record.Updated
+	b.hits = record.Hits
+	b.completions = record.Completions
+	b.completionsFile = record.CompletionsFile
+	b.suggestURL = record.SuggestURL
+	b.suggestFormat = record.SuggestFormat
+
+	return nil
+}
+
+// isLegacyBookmark reports whether val is a bare URL as stored by older
+// versions rather than an encoded bookmark record.
+func isLegacyBookmark(val []byte) bool {
+	return !bytes.HasPrefix(bytes.TrimSpace(val), []byte("{"))
+}
+
+// DecodeBookmark decodes a stored bookmark value. Values written by older
+// versions that consist of just the URL are decoded transparently.
+func DecodeBookmark(name string, val []byte) (bookmark Bookmark, err error) {
+	if isLegacyBookmark(val) {
+		bookmark.name = name
+		bookmark.url = string(val)
+		return
+	}
+
+	if err = json.Unmarshal(val, &bookmark); err != nil {
+		return
+	}
+	if bookmark.name == "" {
+		bookmark.name = name
+	}
+
+	return
+}
+
+// EncodeBookmark encodes a bookmark for storage, without its hit count
+// which is kept in its usage record
+func EncodeBookmark(bookmark Bookmark) ([]byte, error) {
+	bookmark.hits = 0
+	return json.Marshal(bookmark)
+}
+
+func bookmarkKey(name string) []byte {
+	return []byte(fmt.Sprintf("%s%s", BookmarkPrefix, name))
 }
 
 // LookupBookmark ...
 func LookupBookmark(name string) (bookmark Bookmark, ok bool) {
-	key := fmt.Sprintf("bookmark_%s", strings.ToLower(name))
-	val, err := db.Get([]byte(key))
+	name = strings.ToLower(name)
+	val, err := db.Get(bookmarkKey(name))
 	if err != nil {
-		if err == bitcask.ErrKeyNotFound {
+		if err == ErrKeyNotFound {
 			return
 		}
 		log.Printf("error looking up bookmark for %s: %s", name, err)
+		return
 	}
 
-	bookmark.name = name
-	bookmark.url = string(val)
+	bookmark, err = DecodeBookmark(name, val)
+	if err != nil {
+		log.Printf("error decoding bookmark for %s: %s", name, err)
+		return Bookmark{}, false
+	}
+	if err := loadHits(&bookmark); err != nil {
+		log.Printf("error looking up hits for %s: %s", name, err)
+	}
 	ok = true
 
 	return
 }
+
+// loadHits sets the bookmark's hit count from its usage record
+func loadHits(bookmark *Bookmark) error {
+	usage, err := LookupUsage(UsageBookmark, bookmark.name)
+	if err != nil {
+		return err
+	}
+	bookmark.hits = usage.Count
+	return nil
+}
+
+// SaveBookmark ...
+func SaveBookmark(bookmark Bookmark) error {
+	val, err := EncodeBookmark(bookmark)
+	if err != nil {
+		return err
+	}
+	return db.Put(bookmarkKey(bookmark.name), val)
+}
+
+// DeleteBookmark deletes a bookmark but keeps its usage and link status so
+// they come back with it if the deletion is undone or an earlier revision
+// restored. They are only listed for existing bookmarks and cleared when
+// a new bookmark is created with the name (see SaveBookmarkBy).
+func DeleteBookmark(name string) error {
+	return db.Delete(bookmarkKey(strings.ToLower(name)))
+}
+
+// ListBookmarks returns all bookmarks ordered by name
+func ListBookmarks() ([]Bookmark, error) {
+	bookmarks, err := ListBookmarksByPrefix("")
+	if err != nil {
+		return nil, err
+	}
+	for i := range bookmarks {
+		if err := loadHits(&bookmarks[i]); err != nil {
+			return nil, err
+		}
+	}
+	return bookmarks, nil
+}
+
+// ListBookmarksByPrefix returns the bookmarks whose names start with prefix
+// ordered by name. Only the matching bookmarks are read and without their
+// hit counts, which would take another read each.
+func ListBookmarksByPrefix(prefix string) ([]Bookmark, error) {
+	var bookmarks []Bookmark
+
+	err := db.Scan(bookmarkKey(strings.ToLower(prefix)), func(key []byte) error {
+		val, err := db.Get(key)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimPrefix(string(key), BookmarkPrefix)
+		bookmark, err := DecodeBookmark(name, val)
+		if err != nil {
+			return err
+		}
+		bookmarks = append(bookmarks, bookmark)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(bookmarks, func(i, j int) bool {
+		return bookmarks[i].name < bookmarks[j].name
+	})
+
+	return bookmarks, nil
+}
+
+// ValidationError is returned for invalid user input such as malformed
+// bookmark names or URLs
+type ValidationError struct {
+	msg string
+}
+
+func (e *ValidationError) Error() string {
+	return e.msg
+}
+
+func invalidf(format string, args ...interface{}) error {
+	return &ValidationError{msg: fmt.Sprintf(format, args...)}
+}
+
+// ReservedNames are the top-level paths used by golinks itself which can't
+// be used as names of bookmarks as they would not be reachable as short links
+var ReservedNames = []string{
+	"api", "bookmarks", "debug", "export", "help", "history", "import", "list",
+	"metrics", "opensearch", "opensearch.xml", "stats", "suggest",
+}
+
+// ValidateName checks that name is usable as a bookmark name
+func ValidateName(name string) error {
+	if name == "" {
+		return invalidf("name must not be empty")
+	}
+	if strings.ContainsAny(name, " \t\r\n/?#") {
+		return invalidf("invalid name %q: must not contain whitespace, '/', '?' or '#'", name)
+	}
+	for _, reserved := range ReservedNames {
+		if strings.EqualFold(name, reserved) {
+			return invalidf("invalid name %q: reserved", name)
+		}
+	}
+	if LookupCommand(name) != nil {
+		return invalidf("invalid name %q: conflicts with command", name)
+	}
+	if alias, ok := LookupAlias(name); ok {
+		return invalidf("invalid name %q: already an alias for %s", name, alias.Target)
+	}
+	return nil
+}
+
+// ValidateURL checks that url is a valid URLTemplate that expands to an
+// absolute URL
+func ValidateURL(rawurl string) error {
+	t, err := ParseURLTemplate(rawurl)
+	if err != nil {
+		return err
+	}
+
+	// Expand with dummy arguments to check the result is a valid URL
+	expanded, err := t.Expand(strings.Repeat("x ", t.maxPos+1))
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(expanded)
+	if err != nil {
+		return invalidf("invalid url %q: %s", rawurl, err)
+	}
+	if !u.IsAbs() || u.Host == "" {
+		return invalidf("invalid url %q: must be absolute", rawurl)
+	}
+	return nil
+}
+
+// HitBookmark records a use of the bookmark, which is its hit count, unless
+// it was deleted since it was looked up. The bookmark itself is not written
+// so changes made in the meantime are kept.
+func HitBookmark(bookmark Bookmark) error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	if !db.Has(bookmarkKey(bookmark.name)) {
+		return nil
+	}
+	return RecordUsage(UsageBookmark, bookmark.name, time.Now())
+}
+
+// MigrateBookmarks rewrites any bookmarks stored as bare URLs by older
+// versions as versioned records, lowercases names stored as typed, moves
+// hit counts stored with bookmarks to their usage records and escapes
+// literal braces in URLs saved before they were URL templates that would
+// otherwise not parse, and returns the number migrated.
+func MigrateBookmarks() (int, error) {
+	n := 0
+	now := time.Now()
+
+	prefix := []byte(BookmarkPrefix)
+	err := db.Scan(prefix, func(key []byte) error {
+		val, err := db.Get(key)
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimPrefix(string(key), BookmarkPrefix)
+		bookmark, err := DecodeBookmark(name, val)
+		if err != nil {
+			log.Printf("error decoding bookmark for %s: %s", name, err)
+			return nil
+		}
+
+		migrated := false
+		if lower := strings.ToLower(name); lower != name {
+			// Names were stored as typed by the add command before they
+			// were lowercased like everywhere else
+			if db.Has(bookmarkKey(lower)) {
+				log.Printf("not renaming bookmark %s to %s: it already exists", name, lower)
+				return nil
+			}
+			if err := db.Delete(key); err != nil {
+				return err
+			}
+			bookmark.name = lower
+			migrated = true
+		}
+		if isLegacyBookmark(val) {
+			bookmark.created = now
+			bookmark.updated = now
+			migrated = true
+		}
+		if bookmark.hits > 0 {
+			// Hit counts were stored with the bookmark before they were
+			// taken from its usage record
+			if err := MergeUsageCount(UsageBookmark, name, bookmark.hits); err != nil {
+				return err
+			}
+			migrated = true
+		}
+		if escaped, err := EscapeLegacyURL(bookmark.url); err == nil && escaped != bookmark.url {
+			log.Printf("escaping braces in url of %s: %s", name, escaped)
+			bookmark.url = escaped
+			migrated = true
+		}
+		if !migrated {
+			return nil
+		}
+
+		if err := SaveBookmark(bookmark); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+
+	return n, err
+}
+
+// ValidateCompletionsFile checks that name is a plain file name, so that
+// completions files can only be read from the completions directory
+func ValidateCompletionsFile(name string) error {
+	if name == "" {
+		return nil
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return invalidf("invalid completions file %q: must be a file name without a directory", name)
+	}
+	return nil
+}
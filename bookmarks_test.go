@@ -1,10 +1,11 @@
 package main
 
 import (
-	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -48,10 +49,7 @@ func TestBookmarkWithQuery(t *testing.T) {
 
 	assert.Equal(
 		w.Header().Get("Location"),
-		fmt.Sprintf(
-			"https://www.google.com/search?q=%s&btnK",
-			q,
-		),
+		"https://www.google.com/search?q=foo+bar&btnK",
 	)
 }
 
@@ -79,3 +77,204 @@ func TestBookmarkWithoutQuery(t *testing.T) {
 		"https://www.google.com/",
 	)
 }
+
+func TestBookmarkEncodeDecode(t *testing.T) {
+	assert := assert.New(t)
+
+	bookmark := NewBookmark("g", "https://www.google.com/search?q=%s&btnK")
+	bookmark.desc = "Google Search"
+	bookmark.tags = []string{"search"}
+	bookmark.hits = 42
+	bookmark.completions = []string{"golang", "golinks"}
+	bookmark.completionsFile = "searches.txt"
+	bookmark.suggestURL = "https://www.google.com/complete/search?client=firefox&q={query}"
+	bookmark.suggestFormat = SuggestOpenSearch
+
+	val, err := EncodeBookmark(bookmark)
+	assert.Nil(err)
+	assert.Contains(string(val), `"v":1`)
+
+	decoded, err := DecodeBookmark("g", val)
+	assert.Nil(err)
+	assert.Equal("g", decoded.Name())
+	assert.Equal(bookmark.URL(), decoded.URL())
+	assert.Equal("Google Search", decoded.Desc())
+	assert.Equal([]string{"search"}, decoded.Tags())
+	assert.Equal(int64(0), decoded.Hits(), "hits are not stored")
+	assert.Equal([]string{"golang", "golinks"}, decoded.Completions())
+	assert.Equal("searches.txt", decoded.CompletionsFile())
+	assert.Equal(bookmark.SuggestURL(), decoded.SuggestURL())
+	assert.Equal(SuggestOpenSearch, decoded.SuggestFormat())
+	assert.True(bookmark.Created().Equal(decoded.Created()))
+}
+
+func TestDecodeLegacyBookmark(t *testing.T) {
+	assert := assert.New(t)
+
+	bookmark, err := DecodeBookmark("g", []byte("https://www.google.com/"))
+	assert.Nil(err)
+	assert.Equal("g", bookmark.Name())
+	assert.Equal("https://www.google.com/", bookmark.URL())
+	assert.Equal(int64(0), bookmark.Hits())
+}
+
+func TestDecodeUnsupportedBookmark(t *testing.T) {
+	_, err := DecodeBookmark("g", []byte(`{"v":99,"url":"https://www.google.com/"}`))
+	assert.NotNil(t, err)
+}
+
+func TestMigrateBookmarks(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	err := db.Put([]byte("bookmark_legacy"), []byte("https://example.com/?q=%s"))
+	assert.Nil(err)
+
+	n, err := MigrateBookmarks()
+	assert.Nil(err)
+	assert.True(n >= 1)
+
+	val, err := db.Get([]byte("bookmark_legacy"))
+	assert.Nil(err)
+	assert.False(isLegacyBookmark(val))
+
+	bookmark, ok := LookupBookmark("legacy")
+	assert.True(ok)
+	assert.Equal("https://example.com/?q=%s", bookmark.URL())
+	assert.False(bookmark.Created().IsZero())
+
+	n, err = MigrateBookmarks()
+	assert.Nil(err)
+	assert.Equal(0, n)
+
+	assert.Nil(db.Delete([]byte("bookmark_legacy")))
+
+	// Literal braces from before URL templates are escaped if the URL
+	// doesn't parse, valid templates are left alone
+	assert.Nil(db.Put([]byte("bookmark_json"), []byte(`https://example.com/?filter={"q":"%s"}`)))
+	assert.Nil(SaveBookmark(NewBookmark("brace", "https://example.com/{a/?q=%s")))
+	assert.Nil(SaveBookmark(NewBookmark("wiki", "https://example.com/{1}?q={query}")))
+
+	n, err = MigrateBookmarks()
+	assert.Nil(err)
+	assert.Equal(2, n)
+
+	bookmark, _ = LookupBookmark("json")
+	assert.Equal(`https://example.com/?filter={{"q":"%s"}}`, bookmark.URL())
+	tmpl, err := ParseURLTemplate(bookmark.URL())
+	assert.Nil(err)
+	u, err := tmpl.Expand("go")
+	assert.Nil(err)
+	assert.Equal(`https://example.com/?filter={"q":"go"}`, u)
+
+	bookmark, _ = LookupBookmark("brace")
+	assert.Equal("https://example.com/{{a/?q=%s", bookmark.URL())
+	bookmark, _ = LookupBookmark("wiki")
+	assert.Equal("https://example.com/{1}?q={query}", bookmark.URL())
+
+	// Hit counts stored with bookmarks by older versions are moved to their
+	// usage records
+	assert.Nil(db.Put([]byte("bookmark_gh"), []byte(`{"v":1,"name":"gh","url":"https://github.com/","hits":42}`)))
+	assert.Nil(RecordUsage(UsageBookmark, "gh", time.Now()))
+	assert.Nil(db.Put([]byte("bookmark_go"), []byte(`{"v":1,"name":"go","url":"https://golang.org/","hits":1}`)))
+	for i := 0; i < 3; i++ {
+		assert.Nil(RecordUsage(UsageBookmark, "go", time.Now()))
+	}
+
+	n, err = MigrateBookmarks()
+	assert.Nil(err)
+	assert.Equal(2, n)
+
+	bookmark, _ = LookupBookmark("gh")
+	assert.Equal(int64(42), bookmark.Hits())
+	bookmark, _ = LookupBookmark("go")
+	assert.Equal(int64(3), bookmark.Hits())
+
+	val, err = db.Get([]byte("bookmark_gh"))
+	assert.Nil(err)
+	assert.Contains(string(val), `"hits":0`)
+
+	// Mixed case names are lowercased unless that name is taken
+	assert.Nil(db.Put([]byte("bookmark_GitLab"), []byte(`{"v":1,"name":"GitLab","url":"https://gitlab.com/"}`)))
+	assert.Nil(db.Put([]byte("bookmark_GH"), []byte(`{"v":1,"name":"GH","url":"https://example.com/"}`)))
+
+	n, err = MigrateBookmarks()
+	assert.Nil(err)
+	assert.Equal(1, n)
+
+	bookmark, ok = LookupBookmark("gitlab")
+	assert.True(ok)
+	assert.Equal("gitlab", bookmark.Name())
+	assert.False(db.Has([]byte("bookmark_GitLab")))
+	bookmark, _ = LookupBookmark("gh")
+	assert.Equal("https://github.com/", bookmark.URL())
+	assert.True(db.Has([]byte("bookmark_GH")))
+}
+
+func TestListBookmarksByPrefix(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	for _, name := range []string{"go", "godoc", "gh", "g"} {
+		assert.Nil(SaveBookmark(NewBookmark(name, "https://example.com/"+name)))
+	}
+	assert.Nil(SaveAlias(Alias{Name: "golang", Target: "go"}))
+	assert.Nil(SaveAlias(Alias{Name: "github", Target: "gh"}))
+
+	bookmarks, err := ListBookmarksByPrefix("Go")
+	assert.Nil(err)
+	assert.Len(bookmarks, 2)
+	assert.Equal("go", bookmarks[0].Name())
+	assert.Equal("godoc", bookmarks[1].Name())
+
+	bookmarks, err = ListBookmarksByPrefix("")
+	assert.Nil(err)
+	assert.Len(bookmarks, 4)
+
+	aliases, err := ListAliasesByPrefix("go")
+	assert.Nil(err)
+	assert.Len(aliases, 1)
+	assert.Equal("golang", aliases[0].Name)
+}
+
+func TestHitBookmark(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	assert.Nil(SaveBookmark(NewBookmark("wiki", "https://wiki.example.com/")))
+	stale, ok := LookupBookmark("wiki")
+	assert.True(ok)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(HitBookmark(stale))
+		}()
+	}
+	wg.Wait()
+
+	bookmark, ok := LookupBookmark("wiki")
+	assert.True(ok)
+	assert.Equal(int64(20), bookmark.Hits())
+
+	// Changes made since the bookmark was looked up are kept
+	assert.Nil(SaveBookmarkBy("alice", NewBookmark("wiki", "https://wiki.example.org/")))
+	assert.Nil(HitBookmark(stale))
+	bookmark, _ = LookupBookmark("wiki")
+	assert.Equal("https://wiki.example.org/", bookmark.URL())
+	assert.Equal(int64(21), bookmark.Hits())
+
+	// Deleted bookmarks are not brought back
+	assert.Nil(DeleteBookmarkBy("alice", "wiki"))
+	assert.Nil(HitBookmark(stale))
+	_, ok = LookupBookmark("wiki")
+	assert.False(ok)
+}
@@ -12,9 +12,26 @@ import (
 type Command interface {
 	Name() string
 	Desc() string
+	// ReadOnly reports whether the command has no side effects. Commands
+	// that make changes are only executed from POST requests with a valid
+	// CSRF token and otherwise require confirmation.
+	ReadOnly() bool
 	Exec(w http.ResponseWriter, r *http.Request, args []string) error
 }
 
+// Previewer is implemented by commands that make changes to describe what
+// they would change for the confirmation page
+type Previewer interface {
+	Preview(args []string) string
+}
+
+// Completer is implemented by commands that can complete their arguments
+// in search suggestions. args are the arguments typed so far, the last one
+// possibly incomplete, and the completions are values for the last one.
+type Completer interface {
+	Complete(args []string) []string
+}
+
 var commands map[string]Command
 
 func init() {
@@ -26,6 +43,10 @@ func init() {
 	RegisterCommand("time", Time{})
 	RegisterCommand("add", Add{})
 	RegisterCommand("remove", Remove{})
+	RegisterCommand("history", History{})
+	RegisterCommand("undo", Undo{})
+	RegisterCommand("restore", Restore{})
+	RegisterCommand("alias", AliasCommand{})
 }
 
 // RegisterCommand ...
@@ -59,6 +80,11 @@ func (p Ping) Desc() string {
 	`
 }
 
+// ReadOnly ...
+func (p Ping) ReadOnly() bool {
+	return true
+}
+
 // Exec ...
 func (p Ping) Exec(w http.ResponseWriter, r *http.Request, args []string) error {
 	w.Write([]byte(fmt.Sprintf("pong %d", time.Now().Unix())))
@@ -81,6 +107,11 @@ func (p List) Desc() string {
 	`
 }
 
+// ReadOnly ...
+func (p List) ReadOnly() bool {
+	return true
+}
+
 // Exec ...
 func (p List) Exec(w http.ResponseWriter, r *http.Request, args []string) error {
 	http.Redirect(w, r, "/list", http.StatusFound)
@@ -97,14 +128,37 @@ func (p Help) Name() string {
 
 // Desc ...
 func (p Help) Desc() string {
-	return `help
+	return `help [command]
 
-	Display general helpful information
+	Display general helpful information, or how to use the given command.
 	`
 }
 
+// ReadOnly ...
+func (p Help) ReadOnly() bool {
+	return true
+}
+
+// Complete ...
+func (p Help) Complete(args []string) []string {
+	if len(args) != 1 {
+		return nil
+	}
+	return CompleteCommandNames(args[0])
+}
+
 // Exec ...
 func (p Help) Exec(w http.ResponseWriter, r *http.Request, args []string) error {
+	if len(args) == 1 {
+		command := LookupCommand(args[0])
+		if command == nil {
+			return invalidf("command %s does not exist", args[0])
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(command.Desc()))
+		return nil
+	}
+
 	http.Redirect(w, r, "/help", http.StatusFound)
 	return nil
 }
@@ -125,6 +179,11 @@ func (p Date) Desc() string {
 	`
 }
 
+// ReadOnly ...
+func (p Date) ReadOnly() bool {
+	return true
+}
+
 // Exec ...
 func (p Date) Exec(w http.ResponseWriter, r *http.Request, args []string) error {
 	w.Write([]byte(time.Now().Format(http.TimeFormat)))
@@ -147,6 +206,11 @@ func (p Time) Desc() string {
 	`
 }
 
+// ReadOnly ...
+func (p Time) ReadOnly() bool {
+	return true
+}
+
 // Exec ...
 func (p Time) Exec(w http.ResponseWriter, r *http.Request, args []string) error {
 	w.Write([]byte(time.Now().Format("15:04:05")))
@@ -166,29 +230,75 @@ func (p Add) Desc() string {
 	return `add [name] [url]
 
 	Adds a new bookmark with the given name that will redirect to the given
-	url passing arguments as %s. For example:
+	url passing arguments as {query}. For example:
 
-	add g http://google.com/search?btnK&q=%s
+	add g http://google.com/search?btnK&q={query}
 
 	Will add a new command called 'g' which will redirect to Google's search
-	passing in arguments as '%s'
+	passing in arguments as '{query}'. Arguments can also be used one at a
+	time as {1}, {2}, ... or by name, with {rest} for any remaining ones and
+	optional defaults such as {1:main}:
+
+	add gh https://github.com/{owner}/{repo}/tree/{branch:master}
+
+	Arguments are escaped to suit where they appear in the url, use {+1},
+	{+rest}, ... to pass them through unescaped.
 	`
 }
 
+// ReadOnly ...
+func (p Add) ReadOnly() bool {
+	return false
+}
+
+// Preview ...
+func (p Add) Preview(args []string) string {
+	if len(args) != 2 {
+		return ""
+	}
+	if bookmark, ok := LookupBookmark(args[0]); ok {
+		return fmt.Sprintf(
+			"Overwrite bookmark %s (currently %s) with %s",
+			args[0], bookmark.URL(), args[1],
+		)
+	}
+	return fmt.Sprintf("Add new bookmark %s for %s", args[0], args[1])
+}
+
 // Exec ...
 func (p Add) Exec(w http.ResponseWriter, r *http.Request, args []string) error {
 	var name, url string
 
 	if len(args) == 2 {
-		name, url = args[0], args[1]
+		name, url = strings.ToLower(args[0]), args[1]
 	} else {
 		return fmt.Errorf("expected 2 arguments got %d", len(args))
 	}
 
-	key := []byte(fmt.Sprintf("bookmark_%s", name))
-	val := []byte(url)
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	if err := ValidateURL(url); err != nil {
+		return err
+	}
 
-	if err := db.Put(key, val); err != nil {
+	bookmark, ok := LookupBookmark(name)
+	if ok {
+		if err := AuthorizeModify(r, bookmark); err != nil {
+			return err
+		}
+		bookmark.url = url
+		bookmark.updated = time.Now()
+	} else {
+		owner, err := AuthorizeCreate(r)
+		if err != nil {
+			return err
+		}
+		bookmark = NewBookmark(name, url)
+		bookmark.owner = owner
+	}
+
+	if err := SaveBookmarkBy(RequestUserName(r), bookmark); err != nil {
 		log.Printf("put key failed: %s", err)
 		return err
 	}
@@ -210,7 +320,7 @@ func (p Remove) Name() string {
 func (p Remove) Desc() string {
 	return `remove [name]
 
-	Removes an existing bookmark with the given name. For example:
+	Removes an existing bookmark or alias with the given name. For example:
 
 	remove imdb
 
@@ -218,6 +328,33 @@ func (p Remove) Desc() string {
 	`
 }
 
+// ReadOnly ...
+func (p Remove) ReadOnly() bool {
+	return false
+}
+
+// Preview ...
+func (p Remove) Preview(args []string) string {
+	if len(args) != 1 {
+		return ""
+	}
+	if bookmark, ok := LookupBookmark(args[0]); ok {
+		return fmt.Sprintf("Remove bookmark %s for %s", args[0], bookmark.URL())
+	}
+	if alias, ok := LookupAlias(args[0]); ok {
+		return fmt.Sprintf("Remove alias %s for %s", args[0], alias.Target)
+	}
+	return fmt.Sprintf("Bookmark %s does not exist", args[0])
+}
+
+// Complete ...
+func (p Remove) Complete(args []string) []string {
+	if len(args) != 1 {
+		return nil
+	}
+	return CompleteBookmarkNames(args[0], true)
+}
+
 // Exec ...
 func (p Remove) Exec(w http.ResponseWriter, r *http.Request, args []string) error {
 	var name string
@@ -228,8 +365,25 @@ func (p Remove) Exec(w http.ResponseWriter, r *http.Request, args []string) erro
 		return fmt.Errorf("expected 1 arguments got %d", len(args))
 	}
 
-	key := []byte(fmt.Sprintf("bookmark_%s", name))
-	if err := db.Delete(key); err != nil {
+	if alias, ok := LookupAlias(name); ok {
+		if err := AuthorizeModify(r, Bookmark{name: alias.Name, owner: alias.Owner}); err != nil {
+			return err
+		}
+		if err := DeleteAlias(name); err != nil {
+			log.Printf("delete key failed: %s", err)
+			return err
+		}
+		w.Write([]byte("OK"))
+		return nil
+	}
+
+	if bookmark, ok := LookupBookmark(name); ok {
+		if err := AuthorizeModify(r, bookmark); err != nil {
+			return err
+		}
+	}
+
+	if err := DeleteBookmarkBy(RequestUserName(r), name); err != nil {
 		log.Printf("delete key failed: %s", err)
 		return err
 	}
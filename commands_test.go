@@ -7,7 +7,6 @@ import (
 	"testing"
 	"time"
 
-	"github.com/prologic/bitcask"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -24,6 +23,11 @@ func (f Foo) Desc() string {
 	return "foo bar"
 }
 
+// ReadOnly ...
+func (f Foo) ReadOnly() bool {
+	return true
+}
+
 // Exec ...
 func (f Foo) Exec(w http.ResponseWriter, r *http.Request, args []string) error {
 	w.Write([]byte(fmt.Sprintf("foo bar")))
@@ -69,7 +73,7 @@ func TestPingCommand(t *testing.T) {
 func TestListCommand(t *testing.T) {
 	assert := assert.New(t)
 
-	db, _ = bitcask.Open("test.db")
+	db = NewMemoryStore()
 	defer db.Close()
 
 	err := EnsureDefaultBookmarks()
@@ -113,6 +117,13 @@ func TestHelpCommand(t *testing.T) {
 	})
 
 	assert.Equal(w.Header().Get("Location"), "/help")
+
+	w = httptest.NewRecorder()
+	assert.Nil(cmd.Exec(w, r, []string{"ping"}))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(Ping{}.Desc(), w.Body.String())
+
+	assert.Error(cmd.Exec(httptest.NewRecorder(), r, []string{"asdf"}))
 }
 
 func TestTimeCommand(t *testing.T) {
@@ -161,7 +172,7 @@ func TestDateCommand(t *testing.T) {
 func TestAddCommand(t *testing.T) {
 	assert := assert.New(t)
 
-	db, _ = bitcask.Open("test.db")
+	db = NewMemoryStore()
 	defer db.Close()
 
 	cmd := Add{}
@@ -180,12 +191,23 @@ func TestAddCommand(t *testing.T) {
 
 	assert.Equal(bookmark.Name(), "g")
 	assert.Equal(bookmark.URL(), "https://www.google.com/search?q=%s&btnK")
+
+	// Names are lowercased like everywhere else
+	assert.Nil(cmd.Exec(httptest.NewRecorder(), r, []string{"GH", "https://github.com/"}))
+	assert.True(db.Has([]byte("bookmark_gh")))
+	bookmark, ok = LookupBookmark("GH")
+	assert.True(ok)
+	assert.Equal("gh", bookmark.Name())
+
+	assert.Nil(Remove{}.Exec(httptest.NewRecorder(), r, []string{"GH"}))
+	_, ok = LookupBookmark("gh")
+	assert.False(ok)
 }
 
 func TestRemoveCommand(t *testing.T) {
 	assert := assert.New(t)
 
-	db, _ = bitcask.Open("test.db")
+	db = NewMemoryStore()
 	defer db.Close()
 
 	cmd := Remove{}
@@ -205,3 +227,20 @@ func TestRemoveCommand(t *testing.T) {
 	assert.Equal("", bookmark.Name())
 	assert.Equal("", bookmark.URL())
 }
+
+func TestAddCommandInvalidURL(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "?q=add", nil)
+
+	err := Add{}.Exec(w, r, []string{"gh", "https://github.com/{owner"})
+	assert.NotNil(err)
+	assert.Equal(http.StatusBadRequest, ErrorStatus(err))
+
+	_, ok := LookupBookmark("gh")
+	assert.False(ok)
+}
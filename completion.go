@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func hasPrefixFold(s, prefix string) bool {
+	return strings.HasPrefix(strings.ToLower(s), strings.ToLower(prefix))
+}
+
+// CompleteBookmarkNames returns the names of bookmarks, and of aliases if
+// withAliases, starting with prefix
+func CompleteBookmarkNames(prefix string, withAliases bool) []string {
+	var names []string
+
+	err := db.Scan([]byte(BookmarkPrefix+strings.ToLower(prefix)), func(key []byte) error {
+		names = append(names, strings.TrimPrefix(string(key), BookmarkPrefix))
+		return nil
+	})
+	if err != nil {
+		return nil
+	}
+
+	if withAliases {
+		err := db.Scan([]byte(AliasPrefix+strings.ToLower(prefix)), func(key []byte) error {
+			names = append(names, strings.TrimPrefix(string(key), AliasPrefix))
+			return nil
+		})
+		if err != nil {
+			return nil
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// CompleteCommandNames returns the names of commands starting with prefix
+func CompleteCommandNames(prefix string) []string {
+	var names []string
+	for name := range commands {
+		if hasPrefixFold(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CompleteHistoryNames returns the names of bookmarks with a history, which
+// includes deleted ones, starting with prefix
+func CompleteHistoryNames(prefix string) []string {
+	seen := make(map[string]bool)
+
+	err := db.Scan([]byte(HistoryPrefix+strings.ToLower(prefix)), func(key []byte) error {
+		name := strings.TrimPrefix(string(key), HistoryPrefix)
+		if i := strings.LastIndexByte(name, '/'); i >= 0 {
+			name = name[:i]
+		}
+		seen[name] = true
+		return nil
+	})
+	if err != nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ReadCompletionsFile reads the values in the completions file with the
+// given name in dir, one per line. Blank lines and lines starting with #
+// are ignored.
+func ReadCompletionsFile(dir, name string) ([]string, error) {
+	if err := ValidateCompletionsFile(name); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var values []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		values = append(values, line)
+	}
+	return values, scanner.Err()
+}
+
+// BookmarkCompleter completes the arguments of a bookmark with its values,
+// followed by those in its completions file in Dir if there is one
+type BookmarkCompleter struct {
+	Bookmark Bookmark
+	Dir      string
+}
+
+// Complete returns the values starting with all arguments typed so far
+func (c BookmarkCompleter) Complete(args []string) []string {
+	prefix := strings.Join(args, " ")
+
+	values := c.Bookmark.Completions()
+	if c.Bookmark.CompletionsFile() != "" && c.Dir != "" {
+		more, err := ReadCompletionsFile(c.Dir, c.Bookmark.CompletionsFile())
+		if err != nil {
+			log.Printf("error reading completions for %s: %s", c.Bookmark.Name(), err)
+		}
+		values = append(values[:len(values):len(values)], more...)
+	}
+
+	var completions []string
+	for _, value := range values {
+		if hasPrefixFold(value, prefix) {
+			completions = append(completions, value)
+		}
+	}
+	return completions
+}
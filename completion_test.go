@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompleteNames(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	assert.Nil(SaveBookmarkBy("alice", NewBookmark("gh", "https://github.com/")))
+	assert.Nil(SaveBookmarkBy("alice", NewBookmark("ghi", "https://github.com/issues")))
+	assert.Nil(SaveBookmarkBy("alice", NewBookmark("go", "https://golang.org/")))
+	assert.Nil(SaveBookmarkBy("alice", NewBookmark("gone", "https://example.com/")))
+	assert.Nil(DeleteBookmarkBy("alice", "gone"))
+	assert.Nil(SaveAlias(Alias{Name: "ghx", Target: "gh"}))
+
+	assert.Equal([]string{"gh", "ghi"}, CompleteBookmarkNames("gh", false))
+	assert.Equal([]string{"gh", "ghi", "ghx"}, CompleteBookmarkNames("GH", true))
+	assert.Empty(CompleteBookmarkNames("x", true))
+
+	assert.Equal([]string{"remove"}, CompleteCommandNames("rem"))
+	assert.Equal([]string{"help"}, CompleteCommandNames("he"))
+
+	// Deleted bookmarks still have a history
+	assert.Equal([]string{"go", "gone"}, CompleteHistoryNames("go"))
+}
+
+func TestCommandCompletions(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	wiki := NewBookmark("wiki", "https://wiki.example.com/")
+	assert.Nil(SaveBookmarkBy("alice", wiki))
+	wiki.url = "https://en.wikipedia.org/"
+	assert.Nil(SaveBookmarkBy("alice", wiki))
+	assert.Nil(SaveAlias(Alias{Name: "w", Target: "wiki"}))
+
+	assert.Equal([]string{"w", "wiki"}, Remove{}.Complete([]string{"w"}))
+	assert.Nil(Remove{}.Complete([]string{"wiki", ""}))
+
+	assert.Equal([]string{"ping"}, Help{}.Complete([]string{"pi"}))
+
+	assert.Equal([]string{"wiki"}, History{}.Complete([]string{"wi"}))
+	assert.Equal([]string{"wiki"}, Undo{}.Complete([]string{"wi"}))
+	assert.Equal([]string{"wiki"}, Restore{}.Complete([]string{"wi"}))
+	assert.Equal([]string{"2", "1"}, Restore{}.Complete([]string{"wiki", ""}))
+
+	assert.Nil(AliasCommand{}.Complete([]string{"x"}))
+	assert.Equal([]string{"w", "wiki"}, AliasCommand{}.Complete([]string{"x", "w"}))
+	assert.Equal([]string{"ping"}, AliasCommand{}.Complete([]string{"x", "pi"}))
+}
+
+func TestBookmarkCompleter(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "golinks")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	assert.Nil(ioutil.WriteFile(
+		filepath.Join(dir, "repos.txt"),
+		[]byte("# golinks repos\nprologic/golinks\n\n  prologic/bitcask  \nprologic/msgbus\n"),
+		0644,
+	))
+
+	values, err := ReadCompletionsFile(dir, "repos.txt")
+	assert.Nil(err)
+	assert.Equal([]string{"prologic/golinks", "prologic/bitcask", "prologic/msgbus"}, values)
+
+	_, err = ReadCompletionsFile(dir, "../repos.txt")
+	assert.Error(err)
+	_, err = ReadCompletionsFile(dir, "missing.txt")
+	assert.Error(err)
+
+	bookmark := NewBookmark("gh", "https://github.com/{query}")
+	bookmark.completions = []string{"golang/go", "prologic/gopher"}
+	bookmark.completionsFile = "repos.txt"
+
+	completer := BookmarkCompleter{Bookmark: bookmark, Dir: dir}
+	assert.Equal(
+		[]string{"prologic/gopher", "prologic/golinks", "prologic/bitcask", "prologic/msgbus"},
+		completer.Complete([]string{"prologic/"}),
+	)
+	assert.Equal([]string{"golang/go"}, completer.Complete([]string{"Go"}))
+	assert.Empty(completer.Complete([]string{"golang/go", "x"}))
+
+	// Without a completions directory only the values of the bookmark are used
+	completer.Dir = ""
+	assert.Equal([]string{"prologic/gopher"}, completer.Complete([]string{"prologic/"}))
+
+	assert.Nil(ValidateCompletionsFile(""))
+	assert.Nil(ValidateCompletionsFile("repos.txt"))
+	for _, name := range []string{".", "..", "../etc/passwd", "/etc/passwd", `a\b`} {
+		assert.Error(ValidateCompletionsFile(name), name)
+	}
+}
@@ -1,9 +1,58 @@
 package main
 
+import "time"
+
 // Config ...
 type Config struct {
 	Title      string
 	FQDN       string
 	URL        string
 	SuggestURL string
+	Fallback   string
+
+	// OpenSearch description
+	Description   string
+	Contact       string
+	Favicon       string
+	InputEncoding string
+
+	SuggestTimeout time.Duration
+	CompletionsDir string
+
+	// Upstream suggestions cache and rate limit
+	SuggestCacheSize int
+	SuggestCacheTTL  time.Duration
+	SuggestRate      float64
+	SuggestBurst     int
+
+	// Hosts of suggestions services any user may give a bookmark
+	SuggestHosts []string
+
+	// HTTP server
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+
+	// TLS
+	TLSCert      string
+	TLSKey       string
+	HTTPRedirect string
+
+	// Link checker
+	CheckInterval    time.Duration
+	CheckConcurrency int
+
+	// Seed file
+	Seed         string
+	SeedMode     string
+	SeedInterval time.Duration
+
+	// Authentication
+	AuthTokens       string
+	AuthHtpasswd     string
+	AuthProxyHeader  string
+	AuthProxyTrusted []string
+	AuthRequired     bool
+	Admins           []string
 }
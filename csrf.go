@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+)
+
+const (
+	// CSRFCookie is the name of the cookie holding the CSRF token
+	CSRFCookie = "golinks_csrf"
+	// CSRFField is the name of the form field holding the CSRF token
+	CSRFField = "csrf_token"
+)
+
+func newCSRFToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// CSRFToken returns the CSRF token for the client making the request, setting
+// a new one in a cookie if it doesn't have one yet. Forms that trigger
+// changes must submit it back in the CSRFField field.
+func CSRFToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	if cookie, err := r.Cookie(CSRFCookie); err == nil && cookie.Value != "" {
+		return cookie.Value, nil
+	}
+
+	token, err := newCSRFToken()
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return token, nil
+}
+
+// ValidCSRF reports whether the request is a POST whose CSRF token form
+// field matches the client's CSRF cookie
+func ValidCSRF(r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		return false
+	}
+
+	cookie, err := r.Cookie(CSRFCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	token := r.PostFormValue(CSRFField)
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(token)) == 1
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+// newCommandRequest returns a POST request for the command q as submitted
+// from one of our own forms with a valid CSRF token
+func newCommandRequest(q string) *http.Request {
+	form := url.Values{"q": {q}, CSRFField: {"token"}}
+	r, _ := http.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: CSRFCookie, Value: "token"})
+	return r
+}
+
+func TestCSRFToken(t *testing.T) {
+	assert := assert.New(t)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+
+	token, err := CSRFToken(w, r)
+	assert.Nil(err)
+	assert.NotEmpty(token)
+	assert.Contains(w.Header().Get("Set-Cookie"), CSRFCookie+"="+token)
+
+	r.AddCookie(&http.Cookie{Name: CSRFCookie, Value: "foo"})
+	token, err = CSRFToken(httptest.NewRecorder(), r)
+	assert.Nil(err)
+	assert.Equal("foo", token)
+}
+
+func TestValidCSRF(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(ValidCSRF(newCommandRequest("add")))
+
+	r, _ := http.NewRequest("GET", "/?q=add&csrf_token=token", nil)
+	r.AddCookie(&http.Cookie{Name: CSRFCookie, Value: "token"})
+	assert.False(ValidCSRF(r))
+
+	r = newCommandRequest("add")
+	r.Header.Del("Cookie")
+	assert.False(ValidCSRF(r))
+
+	r = newCommandRequest("add")
+	r.Header.Set("Cookie", CSRFCookie+"=asdf")
+	assert.False(ValidCSRF(r))
+}
+
+func TestMutatingCommandConfirm(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	assert.Nil(SaveBookmark(NewBookmark("wiki", "https://wiki.example.com/")))
+
+	s := newTestServer(t, Config{})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/?q=remove%20wiki", nil)
+	s.IndexHandler()(w, r, httprouter.Params{})
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), "Remove bookmark wiki for https://wiki.example.com/")
+	assert.Contains(w.Body.String(), `name="csrf_token"`)
+
+	_, ok := LookupBookmark("wiki")
+	assert.True(ok)
+
+	w = httptest.NewRecorder()
+	s.IndexHandler()(w, newCommandRequest("remove wiki"), httprouter.Params{})
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("OK", w.Body.String())
+
+	_, ok = LookupBookmark("wiki")
+	assert.False(ok)
+}
@@ -2,13 +2,28 @@ package main
 
 import (
 	"net/http/httptest"
+	"time"
 )
 
 const (
 	// DefaultURL redirects to Google Search by default for unknown queries
-	DefaultURL string = "https://www.google.com/search?q=%s&btnK"
+	DefaultURL string = "https://www.google.com/search?q={query}&btnK"
 	// DefaultSuggestURL provides search suggestions from Google
 	DefaultSuggestURL string = "https://suggestqueries.google.com/complete/search?client=firefox&q=%s"
+	// DefaultSuggestTimeout is how long to wait for upstream suggestions
+	// before returning only local ones
+	DefaultSuggestTimeout = time.Second
+
+	// DefaultReadTimeout is how long to wait for a client to send a request
+	DefaultReadTimeout = 10 * time.Second
+	// DefaultWriteTimeout is how long a request may take to be handled and
+	// its response written
+	DefaultWriteTimeout = 30 * time.Second
+	// DefaultIdleTimeout is how long to keep idle keep-alive connections open
+	DefaultIdleTimeout = 120 * time.Second
+	// DefaultShutdownTimeout is how long to wait for in-flight requests to
+	// finish when shutting down
+	DefaultShutdownTimeout = 15 * time.Second
 )
 
 // DefaultBookmarks ...
@@ -16,18 +31,18 @@ var DefaultBookmarks map[string]string
 
 func init() {
 	DefaultBookmarks = map[string]string{
-		"g":    "https://www.google.com/search?q=%s&btnK",
-		"gl":   "https://www.google.com/search?q=%s&btnI",
-		"gh":   "https://github.com/search?q=%s&ref=opensearch",
-		"go":   "https://golang.org/search?q=%s",
-		"wp":   "http://en.wikipedia.org/?search=%s",
-		"py":   "https://docs.python.org/2/search.html?q=%s",
-		"py3":  "https://docs.python.org/3/search.html?q=%s",
-		"yt":   "http://www.youtube.com/results?search_type=search_videos&search_sort=relevance&search_query=%s&search=Search",
-		"gim":  "https://www.google.com/search?q=%s&um=1&ie=UTF-8&hl=en&tbm=isch",
-		"gdef": "http://www.google.com/search?q=define%%3A+%s&hl=en&lr=&oi=definel&defl=all",
-		"imdb": "http://www.imdb.com/find?q=%s",
-		"gm":   "http://maps.google.com/maps?q=%s",
+		"g":    "https://www.google.com/search?q={query}&btnK",
+		"gl":   "https://www.google.com/search?q={query}&btnI",
+		"gh":   "https://github.com/search?q={query}&ref=opensearch",
+		"go":   "https://golang.org/search?q={query}",
+		"wp":   "http://en.wikipedia.org/?search={query}",
+		"py":   "https://docs.python.org/2/search.html?q={query}",
+		"py3":  "https://docs.python.org/3/search.html?q={query}",
+		"yt":   "http://www.youtube.com/results?search_type=search_videos&search_sort=relevance&search_query={query}&search=Search",
+		"gim":  "https://www.google.com/search?q={query}&um=1&ie=UTF-8&hl=en&tbm=isch",
+		"gdef": "http://www.google.com/search?q=define%3A+{query}&hl=en&lr=&oi=definel&defl=all",
+		"imdb": "http://www.imdb.com/find?q={query}",
+		"gm":   "http://maps.google.com/maps?q={query}",
 	}
 }
 
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	// FallbackSearch redirects unknown names to the default search URL
+	FallbackSearch = "search"
+	// FallbackDidYouMean shows a page of close matches for unknown names
+	FallbackDidYouMean = "didyoumean"
+
+	// MaxMatches is the maximum number of close matches shown
+	MaxMatches = 10
+
+	// MinPrefixMatch is the minimum length of a name for it to match all
+	// longer names starting with it, so short names like g don't match
+	// nearly everything
+	MinPrefixMatch = 3
+)
+
+// Match is a bookmark or command whose name closely matches an unknown name
+type Match struct {
+	Name     string
+	Desc     string
+	URL      string
+	Link     string
+	Command  bool
+	Distance int
+}
+
+// maxDistance is the largest edit distance considered a close match for a
+// name, roughly one typo for every three characters
+func maxDistance(name string) int {
+	n := len([]rune(name)) / 3
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// matchName reports whether candidate is a close match for the unknown
+// name and their edit distance. Names being completions of the unknown
+// name are close matches, as are names the unknown name starts with unless
+// they are shorter than MinPrefixMatch.
+func matchName(name, candidate string) (int, bool) {
+	if strings.HasPrefix(candidate, name) ||
+		(len([]rune(candidate)) >= MinPrefixMatch && strings.HasPrefix(name, candidate)) {
+		return EditDistance(name, candidate), true
+	}
+	d := EditDistance(name, candidate)
+	return d, d <= maxDistance(name)
+}
+
+// ClosestMatches returns the bookmarks and commands whose names are within
+// a small edit distance of name or share a prefix with it, closest first
+func ClosestMatches(name string) []Match {
+	name = strings.ToLower(name)
+
+	var matches []Match
+
+	for _, command := range commands {
+		if d, ok := matchName(name, command.Name()); ok {
+			matches = append(matches, Match{
+				Name:     command.Name(),
+				Desc:     strings.TrimSpace(command.Desc()),
+				Command:  true,
+				Distance: d,
+			})
+		}
+	}
+
+	bookmarks, err := ListBookmarks()
+	if err != nil {
+		log.Printf("error reading list of bookmarks: %s", err)
+	}
+	for _, bookmark := range bookmarks {
+		if d, ok := matchName(name, bookmark.Name()); ok {
+			matches = append(matches, Match{
+				Name:     bookmark.Name(),
+				Desc:     bookmark.Desc(),
+				URL:      bookmark.URL(),
+				Distance: d,
+			})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].Name < matches[j].Name
+	})
+
+	if len(matches) > MaxMatches {
+		matches = matches[:MaxMatches]
+	}
+
+	return matches
+}
+
+// didYouMean renders a page listing close matches for an unknown name with
+// links to use them instead and a form to add it as a new bookmark
+func (s *Server) didYouMean(w http.ResponseWriter, r *http.Request, name string, args []string) {
+	s.counters.Inc("n_didyoumean")
+
+	token, err := CSRFToken(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rest := strings.Join(args, " ")
+
+	var search string
+	if s.config.URL != "" {
+		if t, err := ParseURLTemplate(s.config.URL); err == nil {
+			search, _ = t.Expand(name + " " + rest)
+		}
+	}
+
+	matches := ClosestMatches(name)
+	for i, match := range matches {
+		q := strings.TrimSpace(match.Name + " " + rest)
+		matches[i].Link = "/?q=" + url.QueryEscape(q)
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+	s.render("didyoumean", w, map[string]interface{}{
+		"Name":      name,
+		"Args":      rest,
+		"Matches":   matches,
+		"Search":    search,
+		"CSRFToken": token,
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEditDistance(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(0, EditDistance("", ""))
+	assert.Equal(3, EditDistance("", "foo"))
+	assert.Equal(0, EditDistance("wiki", "wiki"))
+	assert.Equal(1, EditDistance("wiki", "wik"))
+	assert.Equal(1, EditDistance("wiki", "wiko"))
+	assert.Equal(1, EditDistance("ab", "ba"))
+	assert.Equal(1, EditDistance("wkii", "wiki"))
+	assert.Equal(3, EditDistance("kitten", "sitting"))
+	assert.Equal(1, EditDistance("café", "cafe"))
+}
+
+func TestClosestMatches(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	assert.Nil(SaveBookmark(NewBookmark("wiki", "https://wiki.example.com/")))
+	assert.Nil(SaveBookmark(NewBookmark("wikipedia", "https://en.wikipedia.org/")))
+	assert.Nil(SaveBookmark(NewBookmark("jira", "https://jira.example.com/")))
+
+	var names []string
+	for _, match := range ClosestMatches("wkii") {
+		names = append(names, match.Name)
+	}
+	assert.Equal([]string{"wiki"}, names)
+
+	names = nil
+	for _, match := range ClosestMatches("wik") {
+		names = append(names, match.Name)
+	}
+	assert.Equal([]string{"wiki", "wikipedia"}, names)
+
+	matches := ClosestMatches("pnig")
+	assert.Len(matches, 1)
+	assert.Equal("ping", matches[0].Name)
+	assert.True(matches[0].Command)
+
+	assert.Empty(ClosestMatches("asdfgh"))
+
+	// Short names only match unknown names starting with them if close
+	assert.Nil(SaveBookmark(NewBookmark("g", "https://www.google.com/")))
+	names = nil
+	for _, match := range ClosestMatches("gitlab") {
+		names = append(names, match.Name)
+	}
+	assert.NotContains(names, "g")
+
+	names = nil
+	for _, match := range ClosestMatches("wikis") {
+		names = append(names, match.Name)
+	}
+	assert.Equal([]string{"wiki"}, names)
+}
+
+func TestDidYouMean(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	assert.Nil(SaveBookmark(NewBookmark("wiki", "https://wiki.example.com/?q={query}")))
+
+	s := newTestServer(t, Config{URL: DefaultURL, Fallback: FallbackDidYouMean})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/?q=wkii%20foo", nil)
+	s.IndexHandler()(w, r, httprouter.Params{})
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	body := w.Body.String()
+	assert.Contains(body, "Did you mean?")
+	assert.Contains(body, `href="/?q=wiki&#43;foo"`)
+	assert.Contains(body, "https://www.google.com/search?q=wkii&#43;foo&amp;btnK")
+	assert.Contains(body, `value="add wkii "`)
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/wkii/foo", nil)
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusNotFound, w.Code)
+	assert.Contains(w.Body.String(), "Did you mean?")
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// NetscapeHeader is the preamble of a Netscape bookmark file
+const NetscapeHeader = `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<!-- This is an automatically generated file.
+     It will be read and overwritten.
+     DO NOT EDIT! -->
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+`
+
+// CSVHeader is the header row of exported CSV files
+var CSVHeader = []string{"name", "url", "desc", "tags"}
+
+// ExportJSON writes bookmarks as a JSON array of bookmark records
+func ExportJSON(w io.Writer, bookmarks []Bookmark) error {
+	if bookmarks == nil {
+		bookmarks = []Bookmark{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(bookmarks)
+}
+
+// ExportCSV writes bookmarks as CSV with a header row
+func ExportCSV(w io.Writer, bookmarks []Bookmark) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(CSVHeader); err != nil {
+		return err
+	}
+	for _, bookmark := range bookmarks {
+		record := []string{
+			bookmark.name,
+			bookmark.url,
+			bookmark.desc,
+			strings.Join(bookmark.tags, ","),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ExportHTML writes bookmarks as a Netscape bookmark file that browsers can
+// import. The bookmark name is exported as the keyword (SHORTCUTURL).
+func ExportHTML(w io.Writer, bookmarks []Bookmark) error {
+	if _, err := io.WriteString(w, NetscapeHeader); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "<DL><p>\n"); err != nil {
+		return err
+	}
+
+	for _, bookmark := range bookmarks {
+		title := bookmark.desc
+		if title == "" {
+			title = bookmark.name
+		}
+
+		_, err := fmt.Fprintf(
+			w,
+			"    <DT><A HREF=\"%s\" ADD_DATE=\"%d\" LAST_MODIFIED=\"%d\" SHORTCUTURL=\"%s\" TAGS=\"%s\">%s</A>\n",
+			html.EscapeString(bookmark.url),
+			bookmark.created.Unix(),
+			bookmark.updated.Unix(),
+			html.EscapeString(bookmark.name),
+			html.EscapeString(strings.Join(bookmark.tags, ",")),
+			html.EscapeString(title),
+		)
+		if err != nil {
+			return err
+		}
+		if bookmark.desc != "" {
+			if _, err := fmt.Fprintf(w, "    <DD>%s\n", html.EscapeString(bookmark.desc)); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, "</DL><p>\n")
+	return err
+}
+
+// ExportHandler ...
+func (s *Server) ExportHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		s.counters.Inc("n_export")
+
+		bookmarks, err := ListBookmarks()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "json"
+		}
+
+		var (
+			contentType string
+			export      func(io.Writer, []Bookmark) error
+		)
+
+		switch format {
+		case "json":
+			contentType = "application/json; charset=utf-8"
+			export = ExportJSON
+		case "csv":
+			contentType = "text/csv; charset=utf-8"
+			export = ExportCSV
+		case "html":
+			contentType = "text/html; charset=utf-8"
+			export = ExportHTML
+		default:
+			http.Error(w, fmt.Sprintf("Invalid format: %s", format), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set(
+			"Content-Disposition",
+			fmt.Sprintf("attachment; filename=\"bookmarks.%s\"", format),
+		)
+		if err := export(w, bookmarks); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
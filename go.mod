@@ -6,10 +6,16 @@ require (
 	github.com/julienschmidt/httprouter v1.2.0
 	github.com/namsral/flag v1.7.4-pre
 	github.com/prologic/bitcask v0.3.4
+	github.com/prometheus/client_golang v1.1.0
 	github.com/rcrowley/go-metrics v0.0.0-20181016184325-3113b8401b8a
 	github.com/stretchr/testify v1.3.0
 	github.com/thoas/stats v0.0.0-20181218120333-e97827ebd7ca
 	github.com/unrolled/logger v0.0.0-20180528161137-f2fe13954c71
+	go.etcd.io/bbolt v1.3.5
+	golang.org/x/crypto v0.10.0
+	golang.org/x/sys v0.10.0 // indirect
+	golang.org/x/time v0.0.0-20190921001708-c4c64cad1fd0
+	gopkg.in/yaml.v2 v2.2.2
 )
 
 go 1.13
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// HistoryPrefix is the key prefix for bookmark revisions
+	HistoryPrefix = "history_"
+
+	// Actions recorded in revisions
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionUndo    = "undo"
+	ActionRestore = "restore"
+)
+
+// historyMu serializes changes to bookmarks made through SaveBookmarkBy and
+// DeleteBookmarkBy so revisions are numbered and recorded in order
+var historyMu sync.Mutex
+
+// Revision is a recorded change to a bookmark. Old is the bookmark before
+// the change and New after it, either is nil if the bookmark didn't exist.
+type Revision struct {
+	Rev    int       `json:"rev"`
+	Name   string    `json:"name"`
+	Action string    `json:"action"`
+	User   string    `json:"user,omitempty"`
+	Time   time.Time `json:"time"`
+	Old    *Bookmark `json:"old,omitempty"`
+	New    *Bookmark `json:"new,omitempty"`
+}
+
+// Summary describes the change in a few words
+func (r Revision) Summary() string {
+	switch {
+	case r.Old == nil && r.New != nil:
+		return fmt.Sprintf("created %s", r.New.url)
+	case r.Old != nil && r.New == nil:
+		return fmt.Sprintf("deleted %s", r.Old.url)
+	case r.Old != nil && r.New != nil && r.Old.url != r.New.url:
+		return fmt.Sprintf("changed %s to %s", r.Old.url, r.New.url)
+	default:
+		return "changed description or tags"
+	}
+}
+
+// historyPrefix is the key prefix for the revisions of a bookmark, names
+// can't contain a / so it never matches revisions of other bookmarks
+func historyPrefix(name string) string {
+	return HistoryPrefix + strings.ToLower(name) + "/"
+}
+
+func revisionKey(name string, rev int) []byte {
+	return []byte(fmt.Sprintf("%s%08d", historyPrefix(name), rev))
+}
+
+// ListRevisions returns all revisions of a bookmark, oldest first
+func ListRevisions(name string) ([]Revision, error) {
+	var revisions []Revision
+
+	err := db.Scan([]byte(historyPrefix(name)), func(key []byte) error {
+		val, err := db.Get(key)
+		if err != nil {
+			return err
+		}
+		var revision Revision
+		if err := json.Unmarshal(val, &revision); err != nil {
+			return err
+		}
+		revisions = append(revisions, revision)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Rev < revisions[j].Rev
+	})
+
+	return revisions, nil
+}
+
+// LookupRevision returns a single revision of a bookmark
+func LookupRevision(name string, rev int) (Revision, error) {
+	var revision Revision
+
+	val, err := db.Get(revisionKey(name, rev))
+	if err == ErrKeyNotFound {
+		return revision, invalidf("bookmark %s has no revision %d", name, rev)
+	} else if err != nil {
+		return revision, err
+	}
+
+	err = json.Unmarshal(val, &revision)
+	return revision, err
+}
+
+// recordRevision stores a change to a bookmark as its next revision, the
+// caller must hold historyMu
+func recordRevision(user, action, name string, before, after *Bookmark) error {
+	revisions, err := ListRevisions(name)
+	if err != nil {
+		return err
+	}
+
+	revision := Revision{
+		Rev:    1,
+		Name:   strings.ToLower(name),
+		Action: action,
+		User:   user,
+		Time:   time.Now(),
+		Old:    before,
+		New:    after,
+	}
+	if n := len(revisions); n > 0 {
+		revision.Rev = revisions[n-1].Rev + 1
+	}
+
+	val, err := json.Marshal(revision)
+	if err != nil {
+		return err
+	}
+	return db.Put(revisionKey(name, revision.Rev), val)
+}
+
+// applyRevision changes a bookmark to target, deleting it if target is nil,
+// and records the change, the caller must hold historyMu
+func applyRevision(user, action, name string, target *Bookmark) error {
+	var current *Bookmark
+	if bookmark, ok := LookupBookmark(name); ok {
+		current = &bookmark
+	}
+
+	if target == nil {
+		if current == nil {
+			return nil
+		}
+		if err := DeleteBookmark(name); err != nil {
+			return err
+		}
+	} else if err := SaveBookmark(*target); err != nil {
+		return err
+	}
+
+	return recordRevision(user, action, name, current, target)
+}
+
+// SaveBookmarkBy saves a bookmark changed by user and records the change
+// in its history. A new bookmark starts without the usage and link status
+// kept for an earlier one of the same name, which only come back with it
+// through RevertBookmark.
+func SaveBookmarkBy(user string, bookmark Bookmark) error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	action := ActionCreate
+	if _, ok := LookupBookmark(bookmark.name); ok {
+		action = ActionUpdate
+	}
+
+	if action == ActionCreate {
+		if err := DeleteUsage(UsageBookmark, bookmark.name); err != nil {
+			return err
+		}
+		if err := DeleteLinkStatus(bookmark.name); err != nil {
+			return err
+		}
+	}
+
+	return applyRevision(user, action, bookmark.name, &bookmark)
+}
+
+// DeleteBookmarkBy deletes a bookmark on behalf of user and records the
+// deletion in its history
+func DeleteBookmarkBy(user, name string) error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	return applyRevision(user, ActionDelete, name, nil)
+}
+
+// UndoTarget returns the bookmark as it was before its last revision, nil
+// if it didn't exist
+func UndoTarget(name string) (*Bookmark, Revision, error) {
+	revisions, err := ListRevisions(name)
+	if err != nil {
+		return nil, Revision{}, err
+	}
+	if len(revisions) == 0 {
+		return nil, Revision{}, invalidf("bookmark %s has no history", name)
+	}
+
+	last := revisions[len(revisions)-1]
+	return last.Old, last, nil
+}
+
+// RestoreTarget returns the bookmark as it was saved by revision rev
+func RestoreTarget(name string, rev int) (*Bookmark, error) {
+	revision, err := LookupRevision(name, rev)
+	if err != nil {
+		return nil, err
+	}
+	if revision.New == nil {
+		return nil, invalidf(
+			"revision %d deleted bookmark %s, restore an earlier revision",
+			rev, name,
+		)
+	}
+	return revision.New, nil
+}
+
+// RevertBookmark changes a bookmark back to target (deleting it if target
+// is nil) on behalf of user and records the change as a new revision
+func RevertBookmark(user, action, name string, target *Bookmark) error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	if target != nil {
+		bookmark := *target
+		bookmark.updated = time.Now()
+		target = &bookmark
+	}
+
+	return applyRevision(user, action, name, target)
+}
+
+// authorizeRevert checks that the request may change the bookmark called
+// name to target
+func authorizeRevert(r *http.Request, name string, target *Bookmark) error {
+	if current, ok := LookupBookmark(name); ok {
+		return AuthorizeModify(r, current)
+	}
+	if target != nil {
+		return AuthorizeModify(r, *target)
+	}
+	return nil
+}
+
+// RequestUserName returns the name of the user making the request, empty
+// if anonymous
+func RequestUserName(r *http.Request) string {
+	if user, _ := RequestUser(r); user != nil {
+		return user.Name
+	}
+	return ""
+}
+
+// History ...
+type History struct{}
+
+// Name ...
+func (p History) Name() string {
+	return "history"
+}
+
+// Desc ...
+func (p History) Desc() string {
+	return `history [name]
+
+	Shows all changes made to the bookmark with the given name, who made
+	them and when. For example:
+
+	history wiki
+	`
+}
+
+// ReadOnly ...
+func (p History) ReadOnly() bool {
+	return true
+}
+
+// Complete ...
+func (p History) Complete(args []string) []string {
+	if len(args) != 1 {
+		return nil
+	}
+	return CompleteHistoryNames(args[0])
+}
+
+// Exec ...
+func (p History) Exec(w http.ResponseWriter, r *http.Request, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected 1 arguments got %d", len(args))
+	}
+
+	http.Redirect(w, r, "/history/"+url.PathEscape(strings.ToLower(args[0])), http.StatusFound)
+	return nil
+}
+
+// Undo ...
+type Undo struct{}
+
+// Name ...
+func (p Undo) Name() string {
+	return "undo"
+}
+
+// Desc ...
+func (p Undo) Desc() string {
+	return `undo [name]
+
+	Undoes the last change to the bookmark with the given name, bringing
+	back a deleted or overwritten bookmark. For example:
+
+	undo wiki
+	`
+}
+
+// ReadOnly ...
+func (p Undo) ReadOnly() bool {
+	return false
+}
+
+// Preview ...
+func (p Undo) Preview(args []string) string {
+	if len(args) != 1 {
+		return ""
+	}
+	_, revision, err := UndoTarget(args[0])
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("Undo revision %d of %s (%s)", revision.Rev, args[0], revision.Summary())
+}
+
+// Complete ...
+func (p Undo) Complete(args []string) []string {
+	if len(args) != 1 {
+		return nil
+	}
+	return CompleteHistoryNames(args[0])
+}
+
+// Exec ...
+func (p Undo) Exec(w http.ResponseWriter, r *http.Request, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected 1 arguments got %d", len(args))
+	}
+	name := args[0]
+
+	target, _, err := UndoTarget(name)
+	if err != nil {
+		return err
+	}
+	if err := authorizeRevert(r, name, target); err != nil {
+		return err
+	}
+
+	if err := RevertBookmark(RequestUserName(r), ActionUndo, name, target); err != nil {
+		log.Printf("undo failed: %s", err)
+		return err
+	}
+
+	w.Write([]byte("OK"))
+
+	return nil
+}
+
+// Restore ...
+type Restore struct{}
+
+// Name ...
+func (p Restore) Name() string {
+	return "restore"
+}
+
+// Desc ...
+func (p Restore) Desc() string {
+	return `restore [name] [rev]
+
+	Restores the bookmark with the given name to how it was saved by the
+	given revision, see history. For example:
+
+	restore wiki 3
+	`
+}
+
+// ReadOnly ...
+func (p Restore) ReadOnly() bool {
+	return false
+}
+
+// Preview ...
+func (p Restore) Preview(args []string) string {
+	if len(args) != 2 {
+		return ""
+	}
+	target, err := RestoreTarget(args[0], SafeParseInt(args[1], 0))
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("Restore bookmark %s to revision %s (%s)", args[0], args[1], target.URL())
+}
+
+// Complete ...
+func (p Restore) Complete(args []string) []string {
+	switch len(args) {
+	case 1:
+		return CompleteHistoryNames(args[0])
+	case 2:
+		revisions, err := ListRevisions(args[0])
+		if err != nil {
+			log.Printf("error listing revisions of %s: %s", args[0], err)
+			return nil
+		}
+		var revs []string
+		for i := len(revisions) - 1; i >= 0; i-- {
+			rev := strconv.Itoa(revisions[i].Rev)
+			if strings.HasPrefix(rev, args[1]) {
+				revs = append(revs, rev)
+			}
+		}
+		return revs
+	}
+	return nil
+}
+
+// Exec ...
+func (p Restore) Exec(w http.ResponseWriter, r *http.Request, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("expected 2 arguments got %d", len(args))
+	}
+	name := args[0]
+
+	target, err := RestoreTarget(name, SafeParseInt(args[1], 0))
+	if err != nil {
+		return err
+	}
+	if err := authorizeRevert(r, name, target); err != nil {
+		return err
+	}
+
+	if err := RevertBookmark(RequestUserName(r), ActionRestore, name, target); err != nil {
+		log.Printf("restore failed: %s", err)
+		return err
+	}
+
+	w.Write([]byte("OK"))
+
+	return nil
+}
+
+// HistoryHandler shows the revisions of a bookmark, newest first
+func (s *Server) HistoryHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		s.counters.Inc("n_history")
+
+		name := strings.ToLower(p.ByName("name"))
+
+		revisions, err := ListRevisions(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		bookmark, ok := LookupBookmark(name)
+		if !ok && len(revisions) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+
+		token, err := CSRFToken(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		for i, j := 0, len(revisions)-1; i < j; i, j = i+1, j-1 {
+			revisions[i], revisions[j] = revisions[j], revisions[i]
+		}
+
+		s.render("history", w, map[string]interface{}{
+			"Name":      name,
+			"Exists":    ok,
+			"Bookmark":  bookmark,
+			"Revisions": revisions,
+			"CSRFToken": token,
+		})
+	}
+}
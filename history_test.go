@@ -0,0 +1,175 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func runCommand(s *Server, q string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, newCommandRequest(q))
+	return w
+}
+
+func TestBookmarkHistory(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	assert.Nil(SaveBookmarkBy("alice", NewBookmark("wiki", "https://wiki.example.com/")))
+
+	bookmark, _ := LookupBookmark("wiki")
+	bookmark.url = "https://en.wikipedia.org/"
+	assert.Nil(SaveBookmarkBy("bob", bookmark))
+
+	assert.Nil(DeleteBookmarkBy("carol", "wiki"))
+	assert.Nil(DeleteBookmarkBy("carol", "asdf"))
+
+	revisions, err := ListRevisions("wiki")
+	assert.Nil(err)
+	assert.Len(revisions, 3)
+
+	assert.Equal(1, revisions[0].Rev)
+	assert.Equal(ActionCreate, revisions[0].Action)
+	assert.Equal("alice", revisions[0].User)
+	assert.Nil(revisions[0].Old)
+	assert.Equal("https://wiki.example.com/", revisions[0].New.URL())
+
+	assert.Equal(ActionUpdate, revisions[1].Action)
+	assert.Equal("https://wiki.example.com/", revisions[1].Old.URL())
+	assert.Equal("https://en.wikipedia.org/", revisions[1].New.URL())
+	assert.Equal("changed https://wiki.example.com/ to https://en.wikipedia.org/", revisions[1].Summary())
+
+	assert.Equal(ActionDelete, revisions[2].Action)
+	assert.Equal("carol", revisions[2].User)
+	assert.Nil(revisions[2].New)
+
+	revisions, err = ListRevisions("asdf")
+	assert.Nil(err)
+	assert.Empty(revisions)
+
+	// Undo brings back the deleted bookmark
+	target, last, err := UndoTarget("wiki")
+	assert.Nil(err)
+	assert.Equal(3, last.Rev)
+	assert.Nil(RevertBookmark("dave", ActionUndo, "wiki", target))
+
+	bookmark, ok := LookupBookmark("wiki")
+	assert.True(ok)
+	assert.Equal("https://en.wikipedia.org/", bookmark.URL())
+
+	// Restore the first version
+	target, err = RestoreTarget("wiki", 1)
+	assert.Nil(err)
+	assert.Nil(RevertBookmark("dave", ActionRestore, "wiki", target))
+
+	bookmark, _ = LookupBookmark("wiki")
+	assert.Equal("https://wiki.example.com/", bookmark.URL())
+
+	revisions, err = ListRevisions("wiki")
+	assert.Nil(err)
+	assert.Len(revisions, 5)
+	assert.Equal(ActionRestore, revisions[4].Action)
+
+	_, err = RestoreTarget("wiki", 3)
+	assert.NotNil(err)
+	_, err = RestoreTarget("wiki", 42)
+	assert.NotNil(err)
+	_, _, err = UndoTarget("asdf")
+	assert.NotNil(err)
+}
+
+func TestHistoryCommands(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	s := newTestServer(t, Config{})
+
+	assert.Equal("OK", runCommand(s, "add wiki https://wiki.example.com/").Body.String())
+	assert.Equal("OK", runCommand(s, "add wiki https://example.com/oops").Body.String())
+
+	// Undo an accidental overwrite
+	assert.Equal("OK", runCommand(s, "undo wiki").Body.String())
+	bookmark, _ := LookupBookmark("wiki")
+	assert.Equal("https://wiki.example.com/", bookmark.URL())
+
+	// Undo an accidental removal
+	assert.Equal("OK", runCommand(s, "remove wiki").Body.String())
+	_, ok := LookupBookmark("wiki")
+	assert.False(ok)
+	assert.Equal("OK", runCommand(s, "undo wiki").Body.String())
+	_, ok = LookupBookmark("wiki")
+	assert.True(ok)
+
+	assert.Equal("OK", runCommand(s, "restore wiki 2").Body.String())
+	bookmark, _ = LookupBookmark("wiki")
+	assert.Equal("https://example.com/oops", bookmark.URL())
+
+	w := runCommand(s, "restore wiki 4")
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	w = runCommand(s, "undo asdf")
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	// Undo asks for confirmation from other sites
+	w = httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/?q=undo+wiki", nil)
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), "Undo revision 6 of wiki")
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/?q=history+wiki", nil)
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusFound, w.Code)
+	assert.Equal("/history/wiki", w.Header().Get("Location"))
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/history/wiki", nil)
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), "restore wiki 2")
+	assert.Contains(w.Body.String(), "https://example.com/oops")
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/history/asdf", nil)
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestHistoryAuth(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	s, cleanup := newAuthServer(t)
+	defer cleanup()
+
+	w := commandAuthRequest(s, "add wiki https://wiki.example.com/", bearer("alicetoken"))
+	assert.Equal("OK", w.Body.String())
+	w = commandAuthRequest(s, "remove wiki", bearer("alicetoken"))
+	assert.Equal("OK", w.Body.String())
+
+	revisions, err := ListRevisions("wiki")
+	assert.Nil(err)
+	assert.Len(revisions, 2)
+	assert.Equal("alice", revisions[0].User)
+
+	// Only the owner can bring back their deleted bookmark
+	w = commandAuthRequest(s, "undo wiki", bearer("bobtoken"))
+	assert.Equal(http.StatusForbidden, w.Code)
+
+	w = commandAuthRequest(s, "undo wiki", bearer("alicetoken"))
+	assert.Equal("OK", w.Body.String())
+
+	bookmark, ok := LookupBookmark("wiki")
+	assert.True(ok)
+	assert.Equal("alice", bookmark.Owner())
+}
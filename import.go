@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// MaxImportSize is the maximum size of an uploaded import file
+const MaxImportSize = 32 << 20
+
+// Import result statuses
+const (
+	ImportCreated   = "created"
+	ImportUpdated   = "updated"
+	ImportSkipped   = "skipped"
+	ImportInvalid   = "invalid"
+	ImportForbidden = "forbidden"
+	ImportFailed    = "failed"
+)
+
+var (
+	netscapeToken = regexp.MustCompile(
+		`(?is)<h3[^>]*>(.*?)</h3>|<a\s([^>]*)>(.*?)</a>|<dd>([^<]*)|<dl[^>]*>|</dl>`,
+	)
+	netscapeAttr = regexp.MustCompile(`(?is)([a-z_]+)\s*=\s*"([^"]*)"`)
+	slugInvalid  = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// ImportOptions ...
+type ImportOptions struct {
+	// DryRun reports what would happen without changing anything
+	DryRun bool
+	// Overwrite replaces existing bookmarks instead of skipping them
+	Overwrite bool
+	// Owner is the owner of newly created bookmarks and the user recorded
+	// in their history
+	Owner string
+	// Authorize if set is called before overwriting an existing bookmark
+	Authorize func(bookmark Bookmark) error
+	// AuthorizeSuggestURL if set is called for suggestions services that
+	// differ from those of the existing bookmarks
+	AuthorizeSuggestURL func(rawurl string) error
+}
+
+// ImportResult is the outcome of importing a single bookmark
+type ImportResult struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ImportReport summarises the outcome of an import
+type ImportReport struct {
+	DryRun  bool           `json:"dry_run"`
+	Created int            `json:"created"`
+	Updated int            `json:"updated"`
+	Skipped int            `json:"skipped"`
+	Failed  int            `json:"failed"`
+	Results []ImportResult `json:"results"`
+}
+
+// Slugify turns an arbitrary title into a bookmark name
+func Slugify(s string) string {
+	s = slugInvalid.ReplaceAllString(strings.ToLower(s), "-")
+	return strings.Trim(s, "-")
+}
+
+func splitTags(s string) (tags []string) {
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return
+}
+
+func appendTag(tags []string, tag string) []string {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return tags
+		}
+	}
+	return append(tags, tag)
+}
+
+// ParseJSON parses a JSON array of bookmark records as written by ExportJSON
+func ParseJSON(r io.Reader) ([]Bookmark, error) {
+	var bookmarks []Bookmark
+	if err := json.NewDecoder(r).Decode(&bookmarks); err != nil {
+		return nil, err
+	}
+	return bookmarks, nil
+}
+
+// ParseCSV parses CSV with a header row naming at least the name and url
+// columns and optionally desc and tags (comma separated)
+func ParseCSV(r io.Reader) ([]Bookmark, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]int)
+	for i, column := range header {
+		columns[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+	for _, column := range []string{"name", "url"} {
+		if _, ok := columns[column]; !ok {
+			return nil, fmt.Errorf("missing column: %s", column)
+		}
+	}
+
+	field := func(record []string, column string) string {
+		i, ok := columns[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var bookmarks []Bookmark
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		bookmark := NewBookmark(field(record, "name"), field(record, "url"))
+		bookmark.desc = field(record, "desc")
+		bookmark.tags = splitTags(field(record, "tags"))
+		bookmarks = append(bookmarks, bookmark)
+	}
+
+	return bookmarks, nil
+}
+
+// ParseNetscape parses a Netscape bookmark file as exported by browsers.
+// The keyword (SHORTCUTURL) is used as the name if present, otherwise one is
+// derived from the title, and the enclosing folders are added as tags.
+func ParseNetscape(r io.Reader) ([]Bookmark, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		bookmarks []Bookmark
+		folders   []string
+		folder    string
+		anchor    bool
+	)
+
+	for _, m := range netscapeToken.FindAllStringSubmatch(string(data), -1) {
+		token := strings.ToLower(m[0])
+		switch {
+		case m[1] != "" || strings.HasPrefix(token, "<h3"):
+			folder = strings.TrimSpace(html.UnescapeString(m[1]))
+			anchor = false
+		case strings.HasPrefix(token, "<dl"):
+			folders = append(folders, folder)
+			folder = ""
+			anchor = false
+		case strings.HasPrefix(token, "</dl"):
+			if len(folders) > 0 {
+				folders = folders[:len(folders)-1]
+			}
+			anchor = false
+		case strings.HasPrefix(token, "<dd"):
+			if anchor && len(bookmarks) > 0 {
+				desc := strings.TrimSpace(html.UnescapeString(m[4]))
+				if desc != "" {
+					bookmarks[len(bookmarks)-1].desc = desc
+				}
+			}
+			anchor = false
+		case strings.HasPrefix(token, "<a"):
+			attrs := make(map[string]string)
+			for _, attr := range netscapeAttr.FindAllStringSubmatch(m[2], -1) {
+				attrs[strings.ToLower(attr[1])] = html.UnescapeString(attr[2])
+			}
+			title := strings.TrimSpace(html.UnescapeString(m[3]))
+			href := attrs["href"]
+
+			name := attrs["shortcuturl"]
+			if name == "" {
+				name = Slugify(title)
+			}
+			if name == "" {
+				if u, err := url.Parse(href); err == nil {
+					name = Slugify(u.Hostname())
+				}
+			}
+
+			bookmark := NewBookmark(strings.ToLower(name), href)
+			if title != name {
+				bookmark.desc = title
+			}
+			bookmark.tags = splitTags(attrs["tags"])
+			for _, f := range folders {
+				if f != "" {
+					bookmark.tags = appendTag(bookmark.tags, f)
+				}
+			}
+			if ts, err := strconv.ParseInt(attrs["add_date"], 10, 64); err == nil && ts > 0 {
+				bookmark.created = time.Unix(ts, 0)
+			}
+
+			bookmarks = append(bookmarks, bookmark)
+			anchor = true
+		}
+	}
+
+	return bookmarks, nil
+}
+
+// DetectFormat guesses the format of an import file from its filename or
+// failing that its content
+func DetectFormat(filename string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return "json"
+	case ".csv":
+		return "csv"
+	case ".html", ".htm":
+		return "html"
+	}
+
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(data, []byte("[")):
+		return "json"
+	case bytes.HasPrefix(data, []byte("<")):
+		return "html"
+	default:
+		return "csv"
+	}
+}
+
+// ParseBookmarks parses bookmarks in the given format
+func ParseBookmarks(format string, r io.Reader) ([]Bookmark, error) {
+	switch format {
+	case "json":
+		return ParseJSON(r)
+	case "csv":
+		return ParseCSV(r)
+	case "html":
+		return ParseNetscape(r)
+	default:
+		return nil, fmt.Errorf("invalid format: %s", format)
+	}
+}
+
+// ImportBookmarks saves the given bookmarks according to opts and reports
+// the outcome for each one
+func ImportBookmarks(bookmarks []Bookmark, opts ImportOptions) ImportReport {
+	report := ImportReport{DryRun: opts.DryRun, Results: []ImportResult{}}
+	seen := make(map[string]bool)
+
+	for _, bookmark := range bookmarks {
+		bookmark.name = strings.ToLower(bookmark.name)
+		result := ImportResult{Name: bookmark.name, URL: bookmark.url}
+
+		if err := ValidateName(bookmark.name); err != nil {
+			result.Status, result.Error = ImportInvalid, err.Error()
+		} else if err := ValidateURL(bookmark.url); err != nil {
+			result.Status, result.Error = ImportInvalid, err.Error()
+		} else if err := ValidateCompletionsFile(bookmark.completionsFile); err != nil {
+			result.Status, result.Error = ImportInvalid, err.Error()
+		} else if err := ValidateSuggestURL(bookmark.suggestURL); err != nil {
+			result.Status, result.Error = ImportInvalid, err.Error()
+		} else if err := ValidateSuggestFormat(bookmark.suggestFormat); err != nil {
+			result.Status, result.Error = ImportInvalid, err.Error()
+		}
+		if result.Status != "" {
+			report.Failed++
+			report.Results = append(report.Results, result)
+			continue
+		}
+
+		existing, exists := LookupBookmark(bookmark.name)
+		exists = exists || seen[bookmark.name]
+		seen[bookmark.name] = true
+
+		if opts.AuthorizeSuggestURL != nil && bookmark.suggestURL != existing.suggestURL {
+			if err := opts.AuthorizeSuggestURL(bookmark.suggestURL); err != nil {
+				result.Status, result.Error = ImportForbidden, err.Error()
+				report.Failed++
+				report.Results = append(report.Results, result)
+				continue
+			}
+		}
+
+		switch {
+		case exists && !opts.Overwrite:
+			result.Status = ImportSkipped
+			report.Skipped++
+			report.Results = append(report.Results, result)
+			continue
+		case exists:
+			result.Status = ImportUpdated
+			if existing.name != "" {
+				if opts.Authorize != nil {
+					if err := opts.Authorize(existing); err != nil {
+						result.Status, result.Error = ImportForbidden, err.Error()
+						report.Failed++
+						report.Results = append(report.Results, result)
+						continue
+					}
+				}
+				bookmark.created = existing.created
+				bookmark.owner = existing.owner
+			}
+		default:
+			result.Status = ImportCreated
+			bookmark.owner = opts.Owner
+		}
+
+		now := time.Now()
+		if bookmark.created.IsZero() {
+			bookmark.created = now
+		}
+		bookmark.updated = now
+
+		if !opts.DryRun {
+			if err := SaveBookmarkBy(opts.Owner, bookmark); err != nil {
+				result.Status, result.Error = ImportFailed, err.Error()
+			}
+		}
+
+		switch result.Status {
+		case ImportCreated:
+			report.Created++
+		case ImportUpdated:
+			report.Updated++
+		default:
+			report.Failed++
+		}
+		report.Results = append(report.Results, result)
+	}
+
+	return report
+}
+
+// ImportHandler accepts an uploaded file (multipart field "file", with the
+// CSRF token) or a raw request body in JSON, CSV or Netscape HTML format. The query parameters
+// format, dry_run and conflict (skip or overwrite) control the import.
+func (s *Server) ImportHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		s.counters.Inc("n_import")
+
+		owner, err := AuthorizeCreate(r)
+		if err != nil {
+			writeAuthError(w, err)
+			return
+		}
+
+		opts := ImportOptions{
+			Owner: owner,
+			Authorize: func(bookmark Bookmark) error {
+				return AuthorizeModify(r, bookmark)
+			},
+			AuthorizeSuggestURL: func(rawurl string) error {
+				return s.AuthorizeSuggestURL(r, rawurl)
+			},
+		}
+
+		query := r.URL.Query()
+		opts.DryRun, _ = strconv.ParseBool(query.Get("dry_run"))
+		switch query.Get("conflict") {
+		case "", "skip":
+		case "overwrite":
+			opts.Overwrite = true
+		default:
+			writeJSONError(w, http.StatusBadRequest, "invalid conflict policy: "+query.Get("conflict"))
+			return
+		}
+
+		var (
+			body     io.Reader
+			filename string
+		)
+
+		r.Body = http.MaxBytesReader(w, r.Body, MaxImportSize)
+
+		// Other sites can make browsers post forms and plain text, so form
+		// uploads must carry the CSRF token and raw bodies must have a type
+		// that forms can't send
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "multipart/form-data":
+			if !ValidCSRF(r) {
+				writeJSONError(w, http.StatusForbidden, "invalid CSRF token")
+				return
+			}
+			file, header, err := r.FormFile("file")
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			defer file.Close()
+			body, filename = file, header.Filename
+		case "", "text/plain", "application/x-www-form-urlencoded":
+			writeJSONError(
+				w, http.StatusUnsupportedMediaType,
+				"content type must be that of the file, e.g. application/json, text/csv or text/html",
+			)
+			return
+		default:
+			body = r.Body
+		}
+
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		format := query.Get("format")
+		if format == "" {
+			format = DetectFormat(filename, data)
+		}
+
+		bookmarks, err := ParseBookmarks(format, bytes.NewReader(data))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, ImportBookmarks(bookmarks, opts))
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testNetscape = `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks Menu</H1>
+<DL><p>
+    <DT><H3 ADD_DATE="1560000000">Work</H3>
+    <DL><p>
+        <DT><A HREF="https://wiki.example.com/?q=%s" ADD_DATE="1560000000" SHORTCUTURL="wiki">Team Wiki</A>
+        <DD>Our &amp; your wiki
+        <DT><H3>Dev</H3>
+        <DL><p>
+            <DT><A HREF="https://github.com/" TAGS="code">GitHub Home</A>
+        </DL><p>
+    </DL><p>
+    <DT><A HREF="javascript:alert(1)">Bookmarklet</A>
+</DL><p>
+`
+
+func TestParseNetscape(t *testing.T) {
+	assert := assert.New(t)
+
+	bookmarks, err := ParseNetscape(strings.NewReader(testNetscape))
+	assert.Nil(err)
+	assert.Len(bookmarks, 3)
+
+	assert.Equal("wiki", bookmarks[0].Name())
+	assert.Equal("https://wiki.example.com/?q=%s", bookmarks[0].URL())
+	assert.Equal("Our & your wiki", bookmarks[0].Desc())
+	assert.Equal([]string{"Work"}, bookmarks[0].Tags())
+	assert.Equal(int64(1560000000), bookmarks[0].Created().Unix())
+
+	assert.Equal("github-home", bookmarks[1].Name())
+	assert.Equal("GitHub Home", bookmarks[1].Desc())
+	assert.Equal([]string{"code", "Work", "Dev"}, bookmarks[1].Tags())
+
+	assert.Equal("bookmarklet", bookmarks[2].Name())
+	assert.Empty(bookmarks[2].Tags())
+}
+
+func TestParseCSV(t *testing.T) {
+	assert := assert.New(t)
+
+	bookmarks, err := ParseCSV(strings.NewReader(
+		"url,name,tags\nhttps://example.com,ex,\"a,b\"\n",
+	))
+	assert.Nil(err)
+	assert.Len(bookmarks, 1)
+	assert.Equal("ex", bookmarks[0].Name())
+	assert.Equal([]string{"a", "b"}, bookmarks[0].Tags())
+
+	_, err = ParseCSV(strings.NewReader("foo,bar\n"))
+	assert.NotNil(err)
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	bookmark := NewBookmark("wiki", "https://wiki.example.com/?q=%s&a=b")
+	bookmark.desc = "Team <Wiki>"
+	bookmark.tags = []string{"work", "docs"}
+
+	for format, export := range map[string]func(*bytes.Buffer) error{
+		"json": func(buf *bytes.Buffer) error { return ExportJSON(buf, []Bookmark{bookmark}) },
+		"csv":  func(buf *bytes.Buffer) error { return ExportCSV(buf, []Bookmark{bookmark}) },
+		"html": func(buf *bytes.Buffer) error { return ExportHTML(buf, []Bookmark{bookmark}) },
+	} {
+		buf := &bytes.Buffer{}
+		assert.Nil(export(buf), format)
+		assert.Equal(format, DetectFormat("", buf.Bytes()))
+
+		bookmarks, err := ParseBookmarks(format, buf)
+		assert.Nil(err, format)
+		assert.Len(bookmarks, 1, format)
+		assert.Equal(bookmark.Name(), bookmarks[0].Name(), format)
+		assert.Equal(bookmark.URL(), bookmarks[0].URL(), format)
+		assert.Equal(bookmark.Desc(), bookmarks[0].Desc(), format)
+		assert.Equal(bookmark.Tags(), bookmarks[0].Tags(), format)
+	}
+}
+
+func TestImportBookmarks(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	assert.Nil(SaveBookmark(NewBookmark("g", "https://www.google.com/")))
+
+	bookmarks := []Bookmark{
+		NewBookmark("g", "https://www.google.com/search?q=%s"),
+		NewBookmark("ddg", "https://duckduckgo.com/?q=%s"),
+		NewBookmark("bad", "not a url"),
+	}
+
+	report := ImportBookmarks(bookmarks, ImportOptions{DryRun: true})
+	assert.Equal(1, report.Created)
+	assert.Equal(1, report.Skipped)
+	assert.Equal(1, report.Failed)
+	assert.Equal(ImportInvalid, report.Results[2].Status)
+	_, ok := LookupBookmark("ddg")
+	assert.False(ok)
+
+	report = ImportBookmarks(bookmarks, ImportOptions{Overwrite: true})
+	assert.Equal(1, report.Created)
+	assert.Equal(1, report.Updated)
+
+	bookmark, ok := LookupBookmark("g")
+	assert.True(ok)
+	assert.Equal("https://www.google.com/search?q=%s", bookmark.URL())
+	_, ok = LookupBookmark("ddg")
+	assert.True(ok)
+
+	// Completions files may only be named, not reached by a path
+	bookmarks, err := ParseJSON(strings.NewReader(
+		`[{"name":"pw","url":"https://example.com/{query}","completions_file":"../../etc/passwd"}]`,
+	))
+	assert.Nil(err)
+	report = ImportBookmarks(bookmarks, ImportOptions{})
+	assert.Equal(1, report.Failed)
+	assert.Equal(ImportInvalid, report.Results[0].Status)
+	_, ok = LookupBookmark("pw")
+	assert.False(ok)
+}
+
+func TestImportExportHandlers(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	s := newTestServer(t, Config{})
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	mw.WriteField(CSRFField, "token")
+	fw, _ := mw.CreateFormFile("file", "bookmarks.html")
+	fw.Write([]byte(testNetscape))
+	mw.Close()
+	upload := body.Bytes()
+
+	// Form uploads without the CSRF token
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/import", bytes.NewReader(upload))
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/import", bytes.NewReader(upload))
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.AddCookie(&http.Cookie{Name: CSRFCookie, Value: "token"})
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+
+	var report ImportReport
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(2, report.Created)
+	assert.Equal(1, report.Failed)
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/import?conflict=asdf", nil)
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	// Content types other sites can send with forms
+	for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+		w = httptest.NewRecorder()
+		r, _ = http.NewRequest("POST", "/import?conflict=overwrite", strings.NewReader("name,url\nevil,https://evil.example.com/\n"))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		s.router.ServeHTTP(w, r)
+		assert.Equal(http.StatusUnsupportedMediaType, w.Code, contentType)
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/import", strings.NewReader("name,url\ngh,https://github.com/\n"))
+	r.Header.Set("Content-Type", "text/csv")
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+	_, ok := LookupBookmark("gh")
+	assert.True(ok)
+	_, ok = LookupBookmark("evil")
+	assert.False(ok)
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/export?format=csv", nil)
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(w.Body.String(), "wiki,https://wiki.example.com/?q=%s")
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/export?format=asdf", nil)
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusBadRequest, w.Code)
+}
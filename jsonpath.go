@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonPathStep selects a member by name, an element by index or all
+// members or elements (wildcard)
+type jsonPathStep struct {
+	name     string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// JSONPath is a simple JSONPath expression to extract values from JSON
+// documents, supporting the root $ followed by any of:
+//
+//	.name or ['name']  the member with the given name
+//	[n]                the nth element (from the end if negative)
+//	.* or [*]          all members or elements
+//
+// For example $[1] selects the second element of an array and
+// $.items[*].title the titles of all elements of the member items.
+type JSONPath struct {
+	raw   string
+	steps []jsonPathStep
+}
+
+// ParseJSONPath ...
+func ParseJSONPath(s string) (*JSONPath, error) {
+	p := &JSONPath{raw: s}
+
+	if !strings.HasPrefix(s, "$") {
+		return nil, invalidf("invalid JSONPath %q: must start with $", s)
+	}
+	rest := s[1:]
+
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, ".*"):
+			p.steps = append(p.steps, jsonPathStep{wildcard: true})
+			rest = rest[2:]
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end == -1 {
+				end = len(rest) - 1
+			}
+			name := rest[1 : end+1]
+			if name == "" {
+				return nil, invalidf("invalid JSONPath %q: empty member name", s)
+			}
+			p.steps = append(p.steps, jsonPathStep{name: name})
+			rest = rest[end+1:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return nil, invalidf("invalid JSONPath %q: unterminated [", s)
+			}
+			sel := strings.TrimSpace(rest[1:end])
+			switch {
+			case sel == "*":
+				p.steps = append(p.steps, jsonPathStep{wildcard: true})
+			case len(sel) >= 2 && (sel[0] == '\'' || sel[0] == '"') && sel[len(sel)-1] == sel[0]:
+				p.steps = append(p.steps, jsonPathStep{name: sel[1 : len(sel)-1]})
+			default:
+				n, err := strconv.Atoi(sel)
+				if err != nil {
+					return nil, invalidf("invalid JSONPath %q: invalid index %q", s, sel)
+				}
+				p.steps = append(p.steps, jsonPathStep{index: n, isIndex: true})
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, invalidf("invalid JSONPath %q: unexpected %q", s, rest[:1])
+		}
+	}
+
+	return p, nil
+}
+
+// String returns the expression as it was parsed
+func (p *JSONPath) String() string {
+	return p.raw
+}
+
+func (step jsonPathStep) apply(value interface{}) (results []interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if step.wildcard {
+			// Members in a stable order as maps are unordered
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				results = append(results, v[key])
+			}
+		} else if member, ok := v[step.name]; ok && !step.isIndex {
+			results = append(results, member)
+		}
+	case []interface{}:
+		if step.wildcard {
+			results = append(results, v...)
+		} else if step.isIndex {
+			i := step.index
+			if i < 0 {
+				i += len(v)
+			}
+			if i >= 0 && i < len(v) {
+				results = append(results, v[i])
+			}
+		}
+	}
+	return
+}
+
+// Strings returns the strings (and numbers, as strings) selected by the
+// expression from the JSON document data, skipping any other values
+func (p *JSONPath) Strings(data []byte) ([]string, error) {
+	var root interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&root); err != nil {
+		return nil, err
+	}
+
+	values := []interface{}{root}
+	for _, step := range p.steps {
+		var next []interface{}
+		for _, value := range values {
+			next = append(next, step.apply(value)...)
+		}
+		values = next
+	}
+
+	var strs []string
+	for _, value := range values {
+		switch v := value.(type) {
+		case string:
+			strs = append(strs, v)
+		case json.Number:
+			strs = append(strs, v.String())
+		}
+	}
+	return strs, nil
+}
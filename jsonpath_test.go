@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONPath(t *testing.T) {
+	assert := assert.New(t)
+
+	data := []byte(`{
+		"query": "go",
+		"items": [
+			{"title": "golang", "rank": 1},
+			{"title": "golinks", "rank": 2},
+			{"name": "gopher"}
+		],
+		"more": {"b": "two", "a": "one", "c": [3]}
+	}`)
+
+	testCases := []struct {
+		path     string
+		expected []string
+	}{
+		{"$", nil},
+		{"$.query", []string{"go"}},
+		{"$['query']", []string{"go"}},
+		{"$.items[*].title", []string{"golang", "golinks"}},
+		{"$.items.*.rank", []string{"1", "2"}},
+		{"$.items[0].title", []string{"golang"}},
+		{"$.items[-1]['name']", []string{"gopher"}},
+		{"$.items[5].title", nil},
+		{"$.more.*", []string{"one", "two"}},
+		{"$.more.c[0]", []string{"3"}},
+		{"$.missing", nil},
+		{"$.query[0]", nil},
+	}
+
+	for _, testCase := range testCases {
+		path, err := ParseJSONPath(testCase.path)
+		assert.Nil(err, testCase.path)
+		assert.Equal(testCase.path, path.String())
+
+		actual, err := path.Strings(data)
+		assert.Nil(err, testCase.path)
+		assert.Equal(testCase.expected, actual, testCase.path)
+	}
+
+	path, err := ParseJSONPath("$[1][*]")
+	assert.Nil(err)
+	actual, err := path.Strings([]byte(`["go",["golang","golinks"]]`))
+	assert.Nil(err)
+	assert.Equal([]string{"golang", "golinks"}, actual)
+
+	_, err = path.Strings([]byte(`not json`))
+	assert.Error(err)
+}
+
+func TestParseJSONPathInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, s := range []string{"", "items", "$.", "$..items", "$[", "$[x]", "$items"} {
+		_, err := ParseJSONPath(s)
+		assert.Error(err, s)
+		assert.Equal(http.StatusBadRequest, ErrorStatus(err), s)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// LinkStatusPrefix is the key prefix for the results of link checks
+	LinkStatusPrefix = "linkcheck_"
+
+	// DefaultCheckInterval is how often all bookmarks are checked
+	DefaultCheckInterval = 24 * time.Hour
+	// DefaultCheckConcurrency is the number of bookmarks checked at once
+	DefaultCheckConcurrency = 4
+)
+
+// LinkStatus is the result of the last check of a bookmark's link
+type LinkStatus struct {
+	Name     string    `json:"name"`
+	URL      string    `json:"url"`
+	Status   int       `json:"status,omitempty"`
+	Redirect string    `json:"redirect,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Checked  time.Time `json:"checked"`
+}
+
+// Broken reports whether the link could not be fetched or returned an error
+func (s LinkStatus) Broken() bool {
+	return s.Error != "" || s.Status >= 400
+}
+
+// Problem describes why the link is broken
+func (s LinkStatus) Problem() string {
+	if s.Error != "" {
+		return s.Error
+	}
+	return fmt.Sprintf("%d %s", s.Status, http.StatusText(s.Status))
+}
+
+// CheckURL returns the URL checked for a bookmark: its URL expanded without
+// arguments, or if some are required the part of the URL before the first
+// placeholder, without the query or up to the last / of the path
+func CheckURL(bookmark Bookmark) (string, error) {
+	t, err := ParseURLTemplate(bookmark.url)
+	if err != nil {
+		return "", err
+	}
+
+	if u, err := t.Expand(""); err == nil {
+		return u, nil
+	}
+
+	var prefix strings.Builder
+	for _, part := range t.parts {
+		if part.kind != literalPart {
+			break
+		}
+		prefix.WriteString(part.literal)
+	}
+
+	u := prefix.String()
+	if i := strings.IndexAny(u, "?#"); i != -1 {
+		u = u[:i]
+	} else if i := strings.Index(u, "://"); i != -1 {
+		if j := strings.LastIndexByte(u[i+3:], '/'); j != -1 {
+			u = u[:i+3+j+1]
+		}
+	}
+
+	return u, nil
+}
+
+func linkStatusKey(name string) []byte {
+	return []byte(LinkStatusPrefix + strings.ToLower(name))
+}
+
+// LookupLinkStatus returns the result of the last check of a bookmark,
+// ignoring results for a URL the bookmark no longer has
+func LookupLinkStatus(bookmark Bookmark) (LinkStatus, bool) {
+	val, err := db.Get(linkStatusKey(bookmark.name))
+	if err != nil {
+		if err != ErrKeyNotFound {
+			log.Printf("error reading link status of %s: %s", bookmark.name, err)
+		}
+		return LinkStatus{}, false
+	}
+
+	var status LinkStatus
+	if err := json.Unmarshal(val, &status); err != nil {
+		log.Printf("error decoding link status of %s: %s", bookmark.name, err)
+		return LinkStatus{}, false
+	}
+
+	if u, err := CheckURL(bookmark); err != nil || u != status.URL {
+		return LinkStatus{}, false
+	}
+
+	return status, true
+}
+
+// SaveLinkStatus stores the result of a link check
+func SaveLinkStatus(status LinkStatus) error {
+	val, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return db.Put(linkStatusKey(status.Name), val)
+}
+
+// DeleteLinkStatus deletes the result of the last check of a bookmark
+func DeleteLinkStatus(name string) error {
+	err := db.Delete(linkStatusKey(name))
+	if err == ErrKeyNotFound {
+		return nil
+	}
+	return err
+}
+
+// ListLinkStatus returns the results of the last checks of all bookmarks
+// keyed by name
+func ListLinkStatus() (map[string]LinkStatus, error) {
+	bookmarks, err := ListBookmarks()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]LinkStatus)
+	for _, bookmark := range bookmarks {
+		if status, ok := LookupLinkStatus(bookmark); ok {
+			statuses[bookmark.name] = status
+		}
+	}
+	return statuses, nil
+}
+
+// LinkChecker periodically checks the links of all bookmarks
+type LinkChecker struct {
+	client      *http.Client
+	interval    time.Duration
+	concurrency int
+}
+
+// NewLinkChecker returns a link checker checking all bookmarks every
+// interval, at most concurrency at a time
+func NewLinkChecker(interval time.Duration, concurrency int) *LinkChecker {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &LinkChecker{
+		client: &http.Client{
+			Timeout: client.Timeout,
+		},
+		interval:    interval,
+		concurrency: concurrency,
+	}
+}
+
+// Check checks a single bookmark's link with a HEAD request, falling back to
+// GET for servers that don't support HEAD
+func (c *LinkChecker) Check(bookmark Bookmark) LinkStatus {
+	status := LinkStatus{Name: bookmark.name, Checked: time.Now()}
+
+	u, err := CheckURL(bookmark)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.URL = u
+
+	resp, err := c.client.Head(u)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed ||
+		resp.StatusCode == http.StatusNotImplemented) {
+		resp.Body.Close()
+		resp, err = c.client.Get(u)
+	}
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	resp.Body.Close()
+
+	status.Status = resp.StatusCode
+	if final := resp.Request.URL.String(); final != u {
+		status.Redirect = final
+	}
+
+	return status
+}
+
+// CheckAll checks the links of all bookmarks and stores the results
+func (c *LinkChecker) CheckAll() error {
+	return c.checkAll(nil)
+}
+
+// checkAll is CheckAll but stops starting new checks once stop is closed
+func (c *LinkChecker) checkAll(stop <-chan struct{}) error {
+	bookmarks, err := ListBookmarks()
+	if err != nil {
+		return err
+	}
+
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, c.concurrency)
+	)
+
+	for _, bookmark := range bookmarks {
+		select {
+		case sem <- struct{}{}:
+		case <-stop:
+		}
+		select {
+		case <-stop:
+			wg.Wait()
+			return nil
+		default:
+		}
+
+		wg.Add(1)
+		go func(bookmark Bookmark) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			status := c.Check(bookmark)
+			if status.Broken() {
+				log.Printf("broken link %s (%s): %s", bookmark.name, status.URL, status.Problem())
+			}
+			if err := SaveLinkStatus(status); err != nil {
+				log.Printf("error saving link status of %s: %s", bookmark.name, err)
+			}
+		}(bookmark)
+	}
+
+	wg.Wait()
+
+	return nil
+}
+
+// Run checks all bookmarks straight away and then every interval until
+// stop is closed
+func (c *LinkChecker) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.checkAll(stop); err != nil {
+			log.Printf("error checking links: %s", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// APIListLinksHandler returns the results of the last link checks, only
+// the broken ones with ?broken=1
+func (s *Server) APIListLinksHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		s.counters.Inc("n_api_links")
+
+		broken := r.URL.Query().Get("broken")
+		onlyBroken := broken == "1" || broken == "true"
+
+		bookmarks, err := ListBookmarks()
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		links := []LinkStatus{}
+		for _, bookmark := range bookmarks {
+			status, ok := LookupLinkStatus(bookmark)
+			if !ok || (onlyBroken && !status.Broken()) {
+				continue
+			}
+			links = append(links, status)
+		}
+
+		writeJSON(w, http.StatusOK, links)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckURL(t *testing.T) {
+	assert := assert.New(t)
+
+	testCases := []struct {
+		url      string
+		expected string
+	}{
+		{"https://example.com/", "https://example.com/"},
+		{"https://wiki.example.com/?q={query}", "https://wiki.example.com/?q="},
+		{"https://en.wikipedia.org/wiki/{1:Main_Page}", "https://en.wikipedia.org/wiki/Main_Page"},
+		{"https://github.com/{owner}/{repo}", "https://github.com/"},
+		{"https://example.com/docs/v{1}/index.html", "https://example.com/docs/"},
+		{"https://example.com/search?q={1}&lang={2}", "https://example.com/search"},
+	}
+
+	for _, tc := range testCases {
+		u, err := CheckURL(NewBookmark("test", tc.url))
+		assert.Nil(err, tc.url)
+		assert.Equal(tc.expected, u, tc.url)
+	}
+}
+
+func TestLinkChecker(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.WriteHeader(http.StatusOK)
+		case "/old":
+			http.Redirect(w, r, "/ok", http.StatusMovedPermanently)
+		case "/nohead":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	assert.Nil(SaveBookmark(NewBookmark("ok", ts.URL+"/ok")))
+	assert.Nil(SaveBookmark(NewBookmark("old", ts.URL+"/old")))
+	assert.Nil(SaveBookmark(NewBookmark("nohead", ts.URL+"/nohead")))
+	assert.Nil(SaveBookmark(NewBookmark("gone", ts.URL+"/gone/{1}")))
+	assert.Nil(SaveBookmark(NewBookmark("down", "http://127.0.0.1:1/")))
+
+	checker := NewLinkChecker(time.Hour, 2)
+	assert.Nil(checker.CheckAll())
+
+	statuses, err := ListLinkStatus()
+	assert.Nil(err)
+	assert.Len(statuses, 5)
+
+	assert.Equal(http.StatusOK, statuses["ok"].Status)
+	assert.False(statuses["ok"].Broken())
+	assert.False(statuses["ok"].Checked.IsZero())
+
+	assert.Equal(http.StatusOK, statuses["old"].Status)
+	assert.Equal(ts.URL+"/ok", statuses["old"].Redirect)
+
+	assert.Equal(http.StatusOK, statuses["nohead"].Status)
+
+	assert.Equal(ts.URL+"/gone/", statuses["gone"].URL)
+	assert.True(statuses["gone"].Broken())
+	assert.Equal("404 Not Found", statuses["gone"].Problem())
+
+	assert.True(statuses["down"].Broken())
+	assert.NotEmpty(statuses["down"].Error)
+
+	// Results for a previous URL are ignored
+	bookmark, _ := LookupBookmark("gone")
+	bookmark.url = ts.URL + "/ok"
+	assert.Nil(SaveBookmark(bookmark))
+	_, ok := LookupLinkStatus(bookmark)
+	assert.False(ok)
+
+	s := newTestServer(t, Config{})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api/v1/links?broken=1", nil)
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+
+	var links []LinkStatus
+	assert.Nil(json.NewDecoder(w.Body).Decode(&links))
+	assert.Len(links, 1)
+	assert.Equal("down", links[0].Name)
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/list", nil)
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), "1 bookmark has a broken link")
+	assert.Contains(w.Body.String(), "redirects to "+ts.URL+"/ok")
+
+	// Deleted bookmarks are no longer listed but keep their link status in
+	// case they are brought back
+	assert.Nil(DeleteBookmarkBy("alice", "down"))
+	statuses, err = ListLinkStatus()
+	assert.Nil(err)
+	assert.NotContains(statuses, "down")
+
+	target, _, err := UndoTarget("down")
+	assert.Nil(err)
+	assert.Nil(RevertBookmark("alice", ActionUndo, "down", target))
+	statuses, err = ListLinkStatus()
+	assert.Nil(err)
+	assert.True(statuses["down"].Broken())
+
+	// but not to a new bookmark of the same name
+	assert.Nil(DeleteBookmarkBy("alice", "down"))
+	assert.Nil(SaveBookmarkBy("bob", NewBookmark("down", "https://other.example.com/")))
+	statuses, err = ListLinkStatus()
+	assert.Nil(err)
+	assert.NotContains(statuses, "down")
+}
+
+func TestLinkCheckerStop(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	var (
+		started = make(chan struct{})
+		release = make(chan struct{})
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+	defer ts.Close()
+
+	assert.Nil(SaveBookmark(NewBookmark("a", ts.URL+"/a")))
+	assert.Nil(SaveBookmark(NewBookmark("b", ts.URL+"/b")))
+	assert.Nil(SaveBookmark(NewBookmark("c", ts.URL+"/c")))
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	checker := NewLinkChecker(time.Hour, 1)
+	go func() {
+		checker.Run(stop)
+		close(done)
+	}()
+
+	// No more checks are started once stopped, the one in progress finishes
+	<-started
+	close(stop)
+	close(release)
+	<-done
+
+	statuses, err := ListLinkStatus()
+	assert.Nil(err)
+	assert.Len(statuses, 1)
+}
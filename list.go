@@ -0,0 +1,259 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// SortName sorts bookmarks by name
+	SortName = "name"
+	// SortPopular sorts the most used bookmarks first
+	SortPopular = "popular"
+	// SortRecent sorts the most recently changed bookmarks first
+	SortRecent = "recent"
+
+	// DefaultPageSize is the number of bookmarks shown per page
+	DefaultPageSize = 50
+)
+
+// SortOrders are the supported ways to sort bookmarks
+var SortOrders = []string{SortName, SortPopular, SortRecent}
+
+// ListOptions are the search, sort and pagination options for the list of
+// bookmarks, as given in the query parameters q, tag, sort, page and
+// per_page
+type ListOptions struct {
+	Query   string
+	Tags    []string
+	Sort    string
+	Page    int
+	PerPage int
+}
+
+// ParseListOptions parses the list options from query parameters, falling
+// back to the defaults for missing or invalid values
+func ParseListOptions(query url.Values) ListOptions {
+	opts := ListOptions{
+		Query:   strings.TrimSpace(query.Get("q")),
+		Sort:    query.Get("sort"),
+		Page:    SafeParseInt(query.Get("page"), 1),
+		PerPage: SafeParseInt(query.Get("per_page"), DefaultPageSize),
+	}
+
+	for _, tag := range query["tag"] {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			opts.Tags = appendTag(opts.Tags, tag)
+		}
+	}
+
+	valid := false
+	for _, order := range SortOrders {
+		if opts.Sort == order {
+			valid = true
+		}
+	}
+	if !valid {
+		opts.Sort = SortName
+	}
+
+	if opts.Page < 1 {
+		opts.Page = 1
+	}
+	if opts.PerPage <= 0 || opts.PerPage > MaxAPILimit {
+		opts.PerPage = DefaultPageSize
+	}
+
+	return opts
+}
+
+// Values returns the options as query parameters, leaving out defaults
+func (o ListOptions) Values() url.Values {
+	values := url.Values{}
+	if o.Query != "" {
+		values.Set("q", o.Query)
+	}
+	for _, tag := range o.Tags {
+		values.Add("tag", tag)
+	}
+	if o.Sort != "" && o.Sort != SortName {
+		values.Set("sort", o.Sort)
+	}
+	if o.Page > 1 {
+		values.Set("page", strconv.Itoa(o.Page))
+	}
+	if o.PerPage != 0 && o.PerPage != DefaultPageSize {
+		values.Set("per_page", strconv.Itoa(o.PerPage))
+	}
+	return values
+}
+
+// URL returns the link to the list page with these options
+func (o ListOptions) URL() string {
+	if values := o.Values(); len(values) > 0 {
+		return "/list?" + values.Encode()
+	}
+	return "/list"
+}
+
+// WithPage returns the link to the given page
+func (o ListOptions) WithPage(page int) string {
+	o.Page = page
+	return o.URL()
+}
+
+// WithSort returns the link to the first page sorted by order
+func (o ListOptions) WithSort(order string) string {
+	o.Sort, o.Page = order, 1
+	return o.URL()
+}
+
+// WithTag returns the link to the first page also filtered by tag
+func (o ListOptions) WithTag(tag string) string {
+	o.Tags, o.Page = appendTag(append([]string(nil), o.Tags...), tag), 1
+	return o.URL()
+}
+
+// WithoutTag returns the link to the first page no longer filtered by tag
+func (o ListOptions) WithoutTag(tag string) string {
+	var tags []string
+	for _, t := range o.Tags {
+		if !strings.EqualFold(t, tag) {
+			tags = append(tags, t)
+		}
+	}
+	o.Tags, o.Page = tags, 1
+	return o.URL()
+}
+
+// SortBookmarks sorts bookmarks in place by the given order, breaking ties
+// by name
+func SortBookmarks(bookmarks []Bookmark, order string) {
+	sort.SliceStable(bookmarks, func(i, j int) bool {
+		a, b := bookmarks[i], bookmarks[j]
+		switch order {
+		case SortPopular:
+			if a.hits != b.hits {
+				return a.hits > b.hits
+			}
+		case SortRecent:
+			if !a.updated.Equal(b.updated) {
+				return a.updated.After(b.updated)
+			}
+		}
+		return a.name < b.name
+	})
+}
+
+// FilterBookmarks returns the bookmarks matching the search query and tags
+func FilterBookmarks(bookmarks []Bookmark, q string, tags []string) []Bookmark {
+	matched := []Bookmark{}
+	for _, bookmark := range bookmarks {
+		if MatchBookmark(bookmark, q, tags) {
+			matched = append(matched, bookmark)
+		}
+	}
+	return matched
+}
+
+// Paginate returns the bookmarks on the given page (starting at 1), none
+// past the last page, and the total number of pages
+func Paginate(bookmarks []Bookmark, page, perPage int) ([]Bookmark, int) {
+	pages := (len(bookmarks) + perPage - 1) / perPage
+	if pages < 1 {
+		pages = 1
+	}
+
+	// Compare pages before multiplying so huge page numbers can't overflow
+	if page < 1 {
+		page = 1
+	}
+	if page > pages {
+		return bookmarks[len(bookmarks):], pages
+	}
+
+	start := (page - 1) * perPage
+	end := start + perPage
+	if end > len(bookmarks) {
+		end = len(bookmarks)
+	}
+
+	return bookmarks[start:end], pages
+}
+
+// ListHandler lists the bookmarks matching the search and tag filters,
+// sorted and paginated, followed by the commands
+func (s *Server) ListHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		s.counters.Inc("n_list")
+
+		opts := ParseListOptions(r.URL.Query())
+
+		bookmarks, err := ListBookmarks()
+		if err != nil {
+			log.Printf("error reading list of bookmarks: %s", err)
+		}
+
+		matched := FilterBookmarks(bookmarks, opts.Query, opts.Tags)
+		SortBookmarks(matched, opts.Sort)
+		page, pages := Paginate(matched, opts.Page, opts.PerPage)
+
+		links, err := ListLinkStatus()
+		if err != nil {
+			log.Printf("error reading link status: %s", err)
+		}
+		broken := 0
+		for _, status := range links {
+			if status.Broken() {
+				broken++
+			}
+		}
+
+		aliases, dangling, err := AliasesByTarget()
+		if err != nil {
+			log.Printf("error reading aliases: %s", err)
+		}
+
+		var names []string
+		for name, command := range commands {
+			if len(opts.Tags) > 0 {
+				continue
+			}
+			if q := strings.ToLower(opts.Query); q != "" &&
+				!strings.Contains(name, q) &&
+				!strings.Contains(strings.ToLower(command.Desc()), q) {
+				continue
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var cmds []Command
+		for _, name := range names {
+			cmds = append(cmds, commands[name])
+		}
+
+		s.render("list", w, map[string]interface{}{
+			"Bookmarks":  page,
+			"Commands":   cmds,
+			"Links":      links,
+			"Aliases":    aliases,
+			"Dangling":   dangling,
+			"Broken":     broken,
+			"Options":    opts,
+			"SortOrders": SortOrders,
+			"Total":      len(matched),
+			"Pages":      pages,
+			"HasPrev":    opts.Page > 1,
+			"HasNext":    opts.Page < pages,
+			"PrevPage":   opts.Page - 1,
+			"NextPage":   opts.Page + 1,
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func bookmarkNames(bookmarks []Bookmark) (names []string) {
+	for _, bookmark := range bookmarks {
+		names = append(names, bookmark.name)
+	}
+	return
+}
+
+func TestParseListOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	opts := ParseListOptions(url.Values{})
+	assert.Equal(ListOptions{Sort: SortName, Page: 1, PerPage: DefaultPageSize}, opts)
+	assert.Equal("/list", opts.URL())
+
+	opts = ParseListOptions(url.Values{
+		"q":        {" wiki "},
+		"tag":      {"docs", "Docs", "go"},
+		"sort":     {"popular"},
+		"page":     {"2"},
+		"per_page": {"10"},
+	})
+	assert.Equal(ListOptions{
+		Query:   "wiki",
+		Tags:    []string{"docs", "go"},
+		Sort:    SortPopular,
+		Page:    2,
+		PerPage: 10,
+	}, opts)
+	assert.Equal("/list?page=2&per_page=10&q=wiki&sort=popular&tag=docs&tag=go", opts.URL())
+	assert.Equal("/list?page=3&per_page=10&q=wiki&sort=popular&tag=docs&tag=go", opts.WithPage(3))
+	assert.Equal("/list?per_page=10&q=wiki&tag=docs&tag=go", opts.WithSort(SortName))
+	assert.Equal("/list?per_page=10&q=wiki&sort=popular&tag=go", opts.WithoutTag("docs"))
+	assert.Equal("/list?per_page=10&q=wiki&sort=popular&tag=docs&tag=go&tag=web", opts.WithTag("web"))
+	assert.Equal([]string{"docs", "go"}, opts.Tags)
+
+	opts = ParseListOptions(url.Values{"sort": {"asdf"}, "page": {"-1"}, "per_page": {"100000"}})
+	assert.Equal(ListOptions{Sort: SortName, Page: 1, PerPage: DefaultPageSize}, opts)
+}
+
+func TestSortBookmarks(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	bookmarks := []Bookmark{
+		{name: "c", hits: 5, updated: now.Add(-time.Hour)},
+		{name: "a", hits: 1, updated: now},
+		{name: "b", hits: 5, updated: now.Add(-2 * time.Hour)},
+	}
+
+	SortBookmarks(bookmarks, SortName)
+	assert.Equal([]string{"a", "b", "c"}, bookmarkNames(bookmarks))
+
+	SortBookmarks(bookmarks, SortPopular)
+	assert.Equal([]string{"b", "c", "a"}, bookmarkNames(bookmarks))
+
+	SortBookmarks(bookmarks, SortRecent)
+	assert.Equal([]string{"a", "c", "b"}, bookmarkNames(bookmarks))
+}
+
+func TestPaginate(t *testing.T) {
+	assert := assert.New(t)
+
+	bookmarks := []Bookmark{{name: "a"}, {name: "b"}, {name: "c"}}
+
+	page, pages := Paginate(bookmarks, 1, 2)
+	assert.Equal([]string{"a", "b"}, bookmarkNames(page))
+	assert.Equal(2, pages)
+
+	page, _ = Paginate(bookmarks, 2, 2)
+	assert.Equal([]string{"c"}, bookmarkNames(page))
+
+	page, _ = Paginate(bookmarks, 5, 2)
+	assert.Empty(page)
+
+	page, pages = Paginate(bookmarks, 4611686018427387906, 2)
+	assert.Empty(page)
+	assert.Equal(2, pages)
+
+	page, pages = Paginate(nil, 1, 2)
+	assert.Empty(page)
+	assert.Equal(1, pages)
+}
+
+func TestListHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	assert.Nil(SaveBookmark(Bookmark{name: "wiki", url: "https://wiki.example.com/", tags: []string{"docs"}}))
+	assert.Nil(SaveBookmark(Bookmark{name: "gh", url: "https://github.com/", desc: "GitHub"}))
+	assert.Nil(RecordUsage(UsageBookmark, "wiki", time.Now()))
+	for i := 0; i < 10; i++ {
+		assert.Nil(RecordUsage(UsageBookmark, "gh", time.Now()))
+	}
+	assert.Nil(SaveBookmark(Bookmark{name: "godoc", url: "https://godoc.org/", tags: []string{"docs", "go"}}))
+
+	s := newTestServer(t, Config{})
+
+	list := func(query string) string {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/list?"+query, nil)
+		s.router.ServeHTTP(w, r)
+		assert.Equal(http.StatusOK, w.Code, query)
+		return w.Body.String()
+	}
+
+	body := list("")
+	assert.Contains(body, "3 bookmarks")
+	assert.Contains(body, "<code>ping</code>")
+
+	body = list("q=github")
+	assert.Contains(body, "1 bookmark\n")
+	assert.Contains(body, "<code>gh</code>")
+	assert.NotContains(body, "<code>wiki</code>")
+	assert.NotContains(body, "<code>ping</code>")
+
+	body = list("q=docs")
+	assert.Contains(body, "2 bookmarks")
+
+	body = list("tag=go")
+	assert.Contains(body, "<code>godoc</code>")
+	assert.NotContains(body, "<code>wiki</code>")
+
+	body = list("sort=popular&per_page=1")
+	assert.Contains(body, "<code>gh</code>")
+	assert.NotContains(body, "<code>wiki</code>")
+	assert.Contains(body, "1 / 3")
+
+	body = list("sort=popular&per_page=1&page=2")
+	assert.Contains(body, "<code>wiki</code>")
+	assert.NotContains(body, "<code>gh</code>")
+
+	body = list("page=4611686018427387906&per_page=2")
+	assert.NotContains(body, "<code>gh</code>")
+}
@@ -1,41 +1,146 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/namsral/flag"
-	"github.com/prologic/bitcask"
 )
 
 var (
-	db  *bitcask.Bitcask
+	db  Store
 	cfg Config
 )
 
 func main() {
 	var (
-		version    bool
-		config     string
-		dbpath     string
-		title      string
-		fqdn       string
-		bind       string
-		url        string
-		suggestURL string
+		version       bool
+		config        string
+		store         string
+		dbpath        string
+		title         string
+		desc          string
+		contact       string
+		favicon       string
+		inputEncoding string
+		fqdn          string
+		bind          string
+		url           string
+		suggestURL    string
+		fallback      string
+
+		suggestTimeout time.Duration
+		completionsDir string
+
+		suggestCacheSize int
+		suggestCacheTTL  time.Duration
+		suggestRate      float64
+		suggestBurst     int
+		suggestHosts     string
+
+		readTimeout     time.Duration
+		writeTimeout    time.Duration
+		idleTimeout     time.Duration
+		shutdownTimeout time.Duration
+
+		tlsCert      string
+		tlsKey       string
+		httpRedirect string
+
+		checkInterval    time.Duration
+		checkConcurrency int
+
+		seed         string
+		seedMode     string
+		seedInterval time.Duration
+
+		authTokens       string
+		authHtpasswd     string
+		authProxyHeader  string
+		authProxyTrusted string
+		authRequired     bool
+		admins           string
 	)
 
 	flag.BoolVar(&version, "v", false, "display version information")
 
 	flag.StringVar(&config, "config", "", "config file")
+	flag.StringVar(&store, "store", "bitcask", "storage backend (bitcask, bolt or memory)")
 	flag.StringVar(&dbpath, "dbpath", "search.db", "database path")
 	flag.StringVar(&title, "title", "Search", "OpenSearch title")
+	flag.StringVar(&desc, "description", DefaultDescription, "OpenSearch description")
+	flag.StringVar(&contact, "contact", "", "OpenSearch contact email address")
+	flag.StringVar(&favicon, "favicon", "",
+		"URL (or path on this server) of the icon for the OpenSearch description")
+	flag.StringVar(&inputEncoding, "input-encoding", DefaultInputEncoding,
+		"character encoding of search terms in the OpenSearch description")
 	flag.StringVar(&bind, "bind", "0.0.0.0:8000", "[int]:<port> to bind to")
 	flag.StringVar(&fqdn, "fqdn", "localhost:8000", "FQDN for public access")
 	flag.StringVar(&url, "url", DefaultURL, "default URL to redirect to")
 	flag.StringVar(&suggestURL, "suggest", DefaultSuggestURL,
 		"default URL to retrieve search suggestions from")
+	flag.DurationVar(&suggestTimeout, "suggest-timeout", DefaultSuggestTimeout,
+		"how long to wait for upstream suggestions before returning only local ones")
+	flag.IntVar(&suggestCacheSize, "suggest-cache-size", DefaultSuggestCacheSize,
+		"number of upstream suggestion responses to cache (0 to disable)")
+	flag.DurationVar(&suggestCacheTTL, "suggest-cache-ttl", DefaultSuggestCacheTTL,
+		"how long to cache upstream suggestions for")
+	flag.Float64Var(&suggestRate, "suggest-rate", DefaultSuggestRate,
+		"upstream suggestion requests per second allowed per client (0 for no limit)")
+	flag.IntVar(&suggestBurst, "suggest-burst", DefaultSuggestBurst,
+		"upstream suggestion requests allowed per client at once")
+	flag.StringVar(&suggestHosts, "suggest-hosts", "",
+		"comma separated hosts (and their subdomains) any user may fetch a bookmark's suggestions from")
+	flag.StringVar(&completionsDir, "completions-dir", "",
+		"directory of files with values to complete bookmark arguments with")
+	flag.StringVar(&fallback, "fallback", FallbackSearch,
+		"what to do for unknown names (search or didyoumean)")
+	flag.DurationVar(&readTimeout, "read-timeout", DefaultReadTimeout,
+		"maximum duration for reading an entire request")
+	flag.DurationVar(&writeTimeout, "write-timeout", DefaultWriteTimeout,
+		"maximum duration for handling a request and writing its response")
+	flag.DurationVar(&idleTimeout, "idle-timeout", DefaultIdleTimeout,
+		"how long to keep idle keep-alive connections open")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", DefaultShutdownTimeout,
+		"how long to wait for in-flight requests to finish on shutdown")
+	flag.StringVar(&tlsCert, "tls-cert", "",
+		"TLS certificate file to serve HTTPS with (reloaded when changed)")
+	flag.StringVar(&tlsKey, "tls-key", "",
+		"TLS private key file for -tls-cert")
+	flag.StringVar(&httpRedirect, "http-redirect", "",
+		"[int]:<port> to redirect plain HTTP requests to HTTPS from (e.g. :80)")
+	flag.DurationVar(&checkInterval, "check-interval", DefaultCheckInterval,
+		"how often to check bookmarks for broken links (0 to disable)")
+	flag.IntVar(&checkConcurrency, "check-concurrency", DefaultCheckConcurrency,
+		"number of bookmarks to check for broken links at once")
+
+	flag.StringVar(&seed, "seed", "",
+		"YAML or JSON file of bookmarks to sync on startup and whenever it changes")
+	flag.StringVar(&seedMode, "seed-mode", SeedMissing,
+		"how to sync the seed file (missing or authoritative)")
+	flag.DurationVar(&seedInterval, "seed-interval", DefaultSeedInterval,
+		"how often to check the seed file for changes")
+
+	flag.StringVar(&authTokens, "auth-tokens", "",
+		"file of API tokens (one \"<token> <user>\" per line)")
+	flag.StringVar(&authHtpasswd, "auth-htpasswd", "",
+		"htpasswd file for HTTP basic authentication (bcrypt or SHA1)")
+	flag.StringVar(&authProxyHeader, "auth-proxy-header", "",
+		"header set by a trusted reverse proxy with the user name (e.g. X-Forwarded-User)")
+	flag.StringVar(&authProxyTrusted, "auth-proxy-trusted", "127.0.0.0/8,::1/128",
+		"comma separated networks of reverse proxies trusted to set the proxy header and X-Forwarded-For")
+	flag.BoolVar(&authRequired, "auth-required", false,
+		"require authentication for all requests, not just changes")
+	flag.StringVar(&admins, "admins", "",
+		"comma separated users allowed to modify any bookmark")
 
 	flag.Parse()
 
@@ -45,23 +150,176 @@ func main() {
 	}
 
 	cfg.Title = title
+	cfg.Description = desc
+	cfg.Contact = contact
+	cfg.Favicon = favicon
+	cfg.InputEncoding = inputEncoding
 	cfg.FQDN = fqdn
 	cfg.URL = url
 	cfg.SuggestURL = suggestURL
+	cfg.Fallback = fallback
+	cfg.SuggestTimeout = suggestTimeout
+	cfg.CompletionsDir = completionsDir
+	cfg.SuggestCacheSize = suggestCacheSize
+	cfg.SuggestCacheTTL = suggestCacheTTL
+	cfg.SuggestRate = suggestRate
+	cfg.SuggestBurst = suggestBurst
+	cfg.SuggestHosts = strings.Split(suggestHosts, ",")
+	cfg.ReadTimeout = readTimeout
+	cfg.WriteTimeout = writeTimeout
+	cfg.IdleTimeout = idleTimeout
+	cfg.ShutdownTimeout = shutdownTimeout
+	cfg.TLSCert = tlsCert
+	cfg.TLSKey = tlsKey
+	cfg.HTTPRedirect = httpRedirect
+	cfg.CheckInterval = checkInterval
+	cfg.CheckConcurrency = checkConcurrency
+
+	cfg.Seed = seed
+	cfg.SeedMode = seedMode
+	cfg.SeedInterval = seedInterval
+
+	cfg.AuthTokens = authTokens
+	cfg.AuthHtpasswd = authHtpasswd
+	cfg.AuthProxyHeader = authProxyHeader
+	cfg.AuthProxyTrusted = strings.Split(authProxyTrusted, ",")
+	cfg.AuthRequired = authRequired
+	cfg.Admins = strings.Split(admins, ",")
 
+	if cfg.Fallback != FallbackSearch && cfg.Fallback != FallbackDidYouMean {
+		log.Fatalf("invalid fallback: %s", cfg.Fallback)
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		log.Fatal("-tls-cert and -tls-key must be given together")
+	}
+	if cfg.HTTPRedirect != "" && cfg.TLSCert == "" {
+		log.Fatal("-http-redirect requires -tls-cert and -tls-key")
+	}
+	if cfg.SeedMode != SeedMissing && cfg.SeedMode != SeedAuthoritative {
+		log.Fatalf("invalid seed mode: %s", cfg.SeedMode)
+	}
+	for name, rawurl := range map[string]*string{"-url": &cfg.URL, "-suggest": &cfg.SuggestURL} {
+		if *rawurl == "" {
+			continue
+		}
+		escaped, err := EscapeLegacyURL(*rawurl)
+		if err != nil {
+			log.Fatalf("invalid %s: %s", name, err)
+		}
+		if escaped != *rawurl {
+			log.Printf("escaping braces in %s: %s", name, escaped)
+			*rawurl = escaped
+		}
+	}
+
+	if err := run(bind, store, dbpath); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run opens the database and serves requests until SIGINT or SIGTERM is
+// received, then waits for in-flight requests and background jobs to finish
+// (up to the shutdown timeout) and closes the database cleanly
+func run(bind, store, dbpath string) error {
 	var err error
-	db, err = bitcask.Open(dbpath)
+	db, err = OpenStore(store, dbpath)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer db.Close()
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Printf("error closing database: %s", err)
+		}
+	}()
 
-	if db.Len() == 0 {
-		err = EnsureDefaultBookmarks()
-		if err != nil {
-			log.Fatal(err)
+	n, err := MigrateBookmarks()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("migrated %d bookmarks", n)
+	}
+
+	var (
+		stop = make(chan struct{})
+		jobs sync.WaitGroup
+	)
+
+	if cfg.Seed != "" {
+		watcher := NewSeedWatcher(cfg.Seed, cfg.SeedMode, cfg.SeedInterval)
+		if _, err := watcher.Sync(); err != nil {
+			return err
+		}
+		if cfg.SeedInterval > 0 {
+			jobs.Add(1)
+			go func() {
+				defer jobs.Done()
+				watcher.Run(stop)
+			}()
+		}
+	} else if db.Len() == 0 {
+		if err := EnsureDefaultBookmarks(); err != nil {
+			return err
 		}
 	}
 
-	NewServer(bind, cfg).ListenAndServe()
+	if cfg.CheckInterval > 0 {
+		checker := NewLinkChecker(cfg.CheckInterval, cfg.CheckConcurrency)
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			checker.Run(stop)
+		}()
+	}
+
+	server, err := NewServer(bind, cfg)
+	if err != nil {
+		return err
+	}
+	if server.certs != nil {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			server.certs.Run(stop)
+		}()
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err = <-errs:
+	case sig := <-signals:
+		log.Printf("received %s, shutting down", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("error shutting down server: %s", err)
+	}
+
+	close(stop)
+	done := make(chan struct{})
+	go func() {
+		jobs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("timed out waiting for background jobs to stop")
+	}
+
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
 }
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// BookmarkForm is the data for the bookmark create/edit form
+type BookmarkForm struct {
+	Name    string
+	URL     string
+	Desc    string
+	Tags    string
+	Example string
+
+	Completions     string
+	CompletionsFile string
+
+	SuggestURL    string
+	SuggestFormat string
+
+	Edit         bool
+	Errors       map[string]string
+	Placeholders []string
+	Preview      string
+	CSRFToken    string
+}
+
+func newBookmarkForm(bookmark Bookmark) *BookmarkForm {
+	return &BookmarkForm{
+		Name:   bookmark.name,
+		URL:    bookmark.url,
+		Desc:   bookmark.desc,
+		Tags:   strings.Join(bookmark.tags, ", "),
+		Errors: make(map[string]string),
+
+		Completions:     strings.Join(bookmark.completions, "\n"),
+		CompletionsFile: bookmark.completionsFile,
+
+		SuggestURL:    bookmark.suggestURL,
+		SuggestFormat: bookmark.suggestFormat,
+	}
+}
+
+func parseBookmarkForm(r *http.Request) *BookmarkForm {
+	return &BookmarkForm{
+		Name:    strings.ToLower(strings.TrimSpace(r.PostFormValue("name"))),
+		URL:     strings.TrimSpace(r.PostFormValue("url")),
+		Desc:    strings.TrimSpace(r.PostFormValue("desc")),
+		Tags:    r.PostFormValue("tags"),
+		Example: r.PostFormValue("example"),
+		Errors:  make(map[string]string),
+
+		Completions:     r.PostFormValue("completions"),
+		CompletionsFile: strings.TrimSpace(r.PostFormValue("completions_file")),
+
+		SuggestURL:    strings.TrimSpace(r.PostFormValue("suggest_url")),
+		SuggestFormat: strings.TrimSpace(r.PostFormValue("suggest_format")),
+	}
+}
+
+// validate checks the form, recording any errors per field, and fills in
+// the placeholders and a preview of the URL for the example arguments. The
+// example arguments are only an error if given and not enough for the URL.
+func (f *BookmarkForm) validate() bool {
+	if !f.Edit {
+		if err := ValidateName(f.Name); err != nil {
+			f.Errors["name"] = err.Error()
+		} else if _, ok := LookupBookmark(f.Name); ok {
+			f.Errors["name"] = "a bookmark with this name already exists"
+		}
+	}
+
+	if err := ValidateURL(f.URL); err != nil {
+		f.Errors["url"] = err.Error()
+	} else if t, err := ParseURLTemplate(f.URL); err == nil {
+		f.Placeholders = t.Placeholders()
+		if preview, err := t.Expand(f.Example); err != nil {
+			if strings.TrimSpace(f.Example) != "" {
+				f.Errors["example"] = err.Error()
+			}
+		} else {
+			f.Preview = preview
+		}
+	}
+
+	if err := ValidateCompletionsFile(f.CompletionsFile); err != nil {
+		f.Errors["completions_file"] = err.Error()
+	}
+	if err := ValidateSuggestURL(f.SuggestURL); err != nil {
+		f.Errors["suggest_url"] = err.Error()
+	}
+	if err := ValidateSuggestFormat(f.SuggestFormat); err != nil {
+		f.Errors["suggest_format"] = err.Error()
+	}
+
+	return len(f.Errors) == 0
+}
+
+// splitLines returns the non-blank lines of s
+func splitLines(s string) (lines []string) {
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return
+}
+
+func (s *Server) renderBookmarkForm(w http.ResponseWriter, r *http.Request, form *BookmarkForm) {
+	token, err := CSRFToken(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	form.CSRFToken = token
+
+	if len(form.Errors) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	s.render("bookmark", w, form)
+}
+
+func (s *Server) authError(w http.ResponseWriter, err error) {
+	if err == ErrUnauthorized {
+		s.auth.Challenge(w, err.Error())
+	} else {
+		http.Error(w, err.Error(), ErrorStatus(err))
+	}
+}
+
+// NewBookmarkHandler shows (GET) and submits (POST) the form to add a new
+// bookmark
+func (s *Server) NewBookmarkHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		s.counters.Inc("n_new")
+
+		owner, err := AuthorizeCreate(r)
+		if err != nil {
+			s.authError(w, err)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			form := newBookmarkForm(Bookmark{name: r.URL.Query().Get("name")})
+			s.renderBookmarkForm(w, r, form)
+			return
+		}
+
+		if !ValidCSRF(r) {
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+
+		form := parseBookmarkForm(r)
+		valid := form.validate()
+		if valid && form.SuggestURL != "" {
+			if err := s.AuthorizeSuggestURL(r, form.SuggestURL); err != nil {
+				form.Errors["suggest_url"] = err.Error()
+				valid = false
+			}
+		}
+		if !valid || r.PostFormValue("action") == "preview" {
+			s.renderBookmarkForm(w, r, form)
+			return
+		}
+
+		bookmark := NewBookmark(form.Name, form.URL)
+		bookmark.owner = owner
+		bookmark.desc = form.Desc
+		bookmark.tags = splitTags(form.Tags)
+		bookmark.completions = splitLines(form.Completions)
+		bookmark.completionsFile = form.CompletionsFile
+		bookmark.suggestURL = form.SuggestURL
+		bookmark.suggestFormat = form.SuggestFormat
+
+		if err := SaveBookmarkBy(RequestUserName(r), bookmark); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/list", http.StatusSeeOther)
+	}
+}
+
+// EditBookmarkHandler shows (GET) and submits (POST) the form to edit an
+// existing bookmark
+func (s *Server) EditBookmarkHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		s.counters.Inc("n_edit")
+
+		bookmark, ok := LookupBookmark(p.ByName("name"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if err := AuthorizeModify(r, bookmark); err != nil {
+			s.authError(w, err)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			form := newBookmarkForm(bookmark)
+			form.Edit = true
+			form.validate()
+			s.renderBookmarkForm(w, r, form)
+			return
+		}
+
+		if !ValidCSRF(r) {
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+
+		form := parseBookmarkForm(r)
+		form.Name = bookmark.name
+		form.Edit = true
+		valid := form.validate()
+		if valid && form.SuggestURL != bookmark.suggestURL {
+			if err := s.AuthorizeSuggestURL(r, form.SuggestURL); err != nil {
+				form.Errors["suggest_url"] = err.Error()
+				valid = false
+			}
+		}
+		if !valid || r.PostFormValue("action") == "preview" {
+			s.renderBookmarkForm(w, r, form)
+			return
+		}
+
+		bookmark.url = form.URL
+		bookmark.desc = form.Desc
+		bookmark.tags = splitTags(form.Tags)
+		bookmark.completions = splitLines(form.Completions)
+		bookmark.completionsFile = form.CompletionsFile
+		bookmark.suggestURL = form.SuggestURL
+		bookmark.suggestFormat = form.SuggestFormat
+		bookmark.updated = time.Now()
+
+		if err := SaveBookmarkBy(RequestUserName(r), bookmark); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/list", http.StatusSeeOther)
+	}
+}
+
+// DeleteBookmarkHandler asks for confirmation (GET) and deletes (POST) a
+// bookmark
+func (s *Server) DeleteBookmarkHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		s.counters.Inc("n_delete")
+
+		bookmark, ok := LookupBookmark(p.ByName("name"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if err := AuthorizeModify(r, bookmark); err != nil {
+			s.authError(w, err)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			token, err := CSRFToken(w, r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			s.render("delete", w, map[string]interface{}{
+				"Bookmark":  bookmark,
+				"CSRFToken": token,
+			})
+			return
+		}
+
+		if !ValidCSRF(r) {
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+
+		if err := DeleteBookmarkBy(RequestUserName(r), bookmark.name); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/list", http.StatusSeeOther)
+	}
+}
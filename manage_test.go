@@ -0,0 +1,195 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// formRequest submits form to path as one of our own forms with a valid
+// CSRF token
+func formRequest(s *Server, path string, form url.Values) *httptest.ResponseRecorder {
+	form.Set(CSRFField, "token")
+	r, _ := http.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: CSRFCookie, Value: "token"})
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, r)
+	return w
+}
+
+func TestNewBookmarkForm(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	s := newTestServer(t, Config{})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/bookmarks/new?name=wiki", nil)
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), `value="wiki"`)
+	assert.Contains(w.Header().Get("Set-Cookie"), CSRFCookie)
+
+	// Preview doesn't save
+	w = formRequest(s, "/bookmarks/new", url.Values{
+		"name":    {"gh"},
+		"url":     {"https://github.com/{owner}/{repo}"},
+		"example": {"prologic golinks"},
+		"action":  {"preview"},
+	})
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), "https://github.com/prologic/golinks")
+	assert.Contains(w.Body.String(), `<span class="label">owner</span>`)
+	_, ok := LookupBookmark("gh")
+	assert.False(ok)
+
+	w = formRequest(s, "/bookmarks/new", url.Values{
+		"name": {"gh"},
+		"url":  {"https://github.com/{owner}/{repo:}"},
+		"desc": {"GitHub"},
+		"tags": {"code, git"},
+	})
+	assert.Equal(http.StatusSeeOther, w.Code)
+	assert.Equal("/list", w.Header().Get("Location"))
+
+	bookmark, ok := LookupBookmark("gh")
+	assert.True(ok)
+	assert.Equal("https://github.com/{owner}/{repo:}", bookmark.URL())
+	assert.Equal("GitHub", bookmark.Desc())
+	assert.Equal([]string{"code", "git"}, bookmark.Tags())
+}
+
+func TestNewBookmarkFormErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	assert.Nil(SaveBookmark(NewBookmark("wiki", "https://wiki.example.com/")))
+
+	s := newTestServer(t, Config{})
+
+	testCases := []struct {
+		form  url.Values
+		error string
+	}{
+		{url.Values{"name": {"wiki"}, "url": {"https://example.com"}}, "already exists"},
+		{url.Values{"name": {"list"}, "url": {"https://example.com"}}, "reserved"},
+		{url.Values{"name": {"foo"}, "url": {"example.com"}}, "invalid url"},
+		{url.Values{"name": {"foo"}, "url": {"https://example.com/{1}/{2}"}, "example": {"a"}}, "missing argument {2}"},
+		{url.Values{"name": {"foo"}, "url": {"https://example.com"}, "suggest_url": {"/suggest"}}, "must be absolute"},
+		{url.Values{"name": {"foo"}, "url": {"https://example.com"}, "suggest_format": {"xml"}}, "invalid suggest format"},
+	}
+
+	for _, tc := range testCases {
+		w := formRequest(s, "/bookmarks/new", tc.form)
+		assert.Equal(http.StatusBadRequest, w.Code, tc.error)
+		assert.Contains(w.Body.String(), "has-error", tc.error)
+		assert.Contains(w.Body.String(), tc.error)
+	}
+
+	_, ok := LookupBookmark("foo")
+	assert.False(ok)
+
+	// Missing CSRF token
+	form := url.Values{"name": {"foo"}, "url": {"https://example.com"}}
+	r, _ := http.NewRequest("POST", "/bookmarks/new", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusForbidden, w.Code)
+}
+
+func TestEditBookmarkForm(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	assert.Nil(SaveBookmark(NewBookmark("wiki", "https://wiki.example.com/?q={query}")))
+
+	s := newTestServer(t, Config{})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/bookmarks/edit/wiki", nil)
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), "https://wiki.example.com/?q={query}")
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/bookmarks/edit/asdf", nil)
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	w = formRequest(s, "/bookmarks/edit/wiki", url.Values{"url": {"wiki.example.com"}})
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	w = formRequest(s, "/bookmarks/edit/wiki", url.Values{
+		"name": {"other"},
+		"url":  {"https://en.wikipedia.org/wiki/{1:Main_Page}"},
+		"desc": {"Wikipedia"},
+	})
+	assert.Equal(http.StatusSeeOther, w.Code)
+
+	bookmark, ok := LookupBookmark("wiki")
+	assert.True(ok)
+	assert.Equal("https://en.wikipedia.org/wiki/{1:Main_Page}", bookmark.URL())
+	assert.Equal("Wikipedia", bookmark.Desc())
+
+	_, ok = LookupBookmark("other")
+	assert.False(ok)
+}
+
+func TestDeleteBookmarkForm(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	assert.Nil(SaveBookmark(NewBookmark("wiki", "https://wiki.example.com/")))
+
+	s := newTestServer(t, Config{})
+
+	// GET only asks for confirmation
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/bookmarks/delete/wiki", nil)
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), "Are you sure")
+	_, ok := LookupBookmark("wiki")
+	assert.True(ok)
+
+	w = formRequest(s, "/bookmarks/delete/wiki", url.Values{})
+	assert.Equal(http.StatusSeeOther, w.Code)
+	_, ok = LookupBookmark("wiki")
+	assert.False(ok)
+}
+
+func TestManageBookmarkAuth(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	s, cleanup := newAuthServer(t)
+	defer cleanup()
+
+	assert.Nil(SaveBookmark(Bookmark{name: "wiki", url: "https://wiki.example.com/", owner: "alice"}))
+
+	w := authRequest(s, "GET", "/bookmarks/edit/wiki", "", bearer("bobtoken"))
+	assert.Equal(http.StatusForbidden, w.Code)
+
+	w = authRequest(s, "GET", "/bookmarks/edit/wiki", "", bearer("alicetoken"))
+	assert.Equal(http.StatusOK, w.Code)
+
+	w = authRequest(s, "GET", "/bookmarks/delete/wiki", "", nil)
+	assert.Equal(http.StatusUnauthorized, w.Code)
+}
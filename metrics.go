@@ -0,0 +1,242 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Resolution types counted by resolutionsTotal
+const (
+	ResolveCommand  = "command"
+	ResolveBookmark = "bookmark"
+	ResolveFallback = "fallback"
+	ResolveUnknown  = "unknown"
+)
+
+var (
+	// metricsRegistry holds all metrics exposed on /metrics
+	metricsRegistry = prometheus.NewRegistry()
+
+	httpRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "golinks",
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests by handler, method and status code.",
+		},
+		[]string{"handler", "method", "code"},
+	)
+	httpRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "golinks",
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests by handler.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"handler"},
+	)
+
+	resolutionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "golinks",
+			Name:      "resolutions_total",
+			Help:      "Number of names resolved by type (command, bookmark, fallback or unknown).",
+		},
+		[]string{"type"},
+	)
+	commandExecutionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "golinks",
+			Name:      "command_executions_total",
+			Help:      "Number of command executions by command and result.",
+		},
+		[]string{"command", "result"},
+	)
+
+	suggestUpstreamDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "golinks",
+			Name:      "suggest_upstream_duration_seconds",
+			Help:      "Latency of requests to the upstream suggestions service.",
+			Buckets:   prometheus.DefBuckets,
+		},
+	)
+	suggestUpstreamErrorsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "golinks",
+			Name:      "suggest_upstream_errors_total",
+			Help:      "Number of failed requests to the upstream suggestions service.",
+		},
+	)
+
+	suggestCacheRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "golinks",
+			Name:      "suggest_cache_requests_total",
+			Help:      "Number of lookups in the upstream suggestions cache by result (hit or miss).",
+		},
+		[]string{"result"},
+	)
+	suggestCoalescedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "golinks",
+			Name:      "suggest_coalesced_total",
+			Help:      "Number of upstream suggestion requests served by an identical request in progress.",
+		},
+	)
+	suggestRateLimitedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "golinks",
+			Name:      "suggest_rate_limited_total",
+			Help:      "Number of upstream suggestion requests skipped as the client exceeded its rate limit.",
+		},
+	)
+
+	storeOperationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "golinks",
+			Name:      "store_operation_duration_seconds",
+			Help:      "Latency of storage operations by store and operation.",
+			Buckets:   []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1},
+		},
+		[]string{"store", "op"},
+	)
+	storeOperationErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "golinks",
+			Name:      "store_operation_errors_total",
+			Help:      "Number of failed storage operations by store and operation.",
+		},
+		[]string{"store", "op"},
+	)
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		httpRequestsTotal,
+		httpRequestDuration,
+		resolutionsTotal,
+		commandExecutionsTotal,
+		suggestUpstreamDuration,
+		suggestUpstreamErrorsTotal,
+		suggestCacheRequestsTotal,
+		suggestCoalescedTotal,
+		suggestRateLimitedTotal,
+		storeOperationDuration,
+		storeOperationErrorsTotal,
+	)
+}
+
+// MetricsHandler serves all metrics in the Prometheus text exposition format
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// statusWriter records the status code written by a handler
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) code() string {
+	if w.status == 0 {
+		return strconv.Itoa(http.StatusOK)
+	}
+	return strconv.Itoa(w.status)
+}
+
+// instrumentHandler records the number and latency of requests served by h
+// labelled with the given handler name
+func instrumentHandler(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+
+		h.ServeHTTP(sw, r)
+
+		httpRequestDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+		httpRequestsTotal.WithLabelValues(name, r.Method, sw.code()).Inc()
+	})
+}
+
+// instrumentHandle is instrumentHandler for router handles
+func instrumentHandle(name string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		instrumentHandler(name, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h(w, r, p)
+		})).ServeHTTP(w, r)
+	}
+}
+
+// instrumentedStore records the latency and errors of the operations of a
+// Store. Scans are timed including the callbacks for each key.
+type instrumentedStore struct {
+	Store
+	name string
+}
+
+// InstrumentStore wraps store to record metrics for its operations
+func InstrumentStore(name string, store Store) Store {
+	return &instrumentedStore{Store: store, name: name}
+}
+
+func (s *instrumentedStore) observe(op string, start time.Time, err error) {
+	storeOperationDuration.WithLabelValues(s.name, op).Observe(time.Since(start).Seconds())
+	if err != nil && err != ErrKeyNotFound {
+		storeOperationErrorsTotal.WithLabelValues(s.name, op).Inc()
+	}
+}
+
+// Get ...
+func (s *instrumentedStore) Get(key []byte) (val []byte, err error) {
+	start := time.Now()
+	defer func() { s.observe("get", start, err) }()
+	return s.Store.Get(key)
+}
+
+// Has ...
+func (s *instrumentedStore) Has(key []byte) bool {
+	defer s.observe("has", time.Now(), nil)
+	return s.Store.Has(key)
+}
+
+// Put ...
+func (s *instrumentedStore) Put(key, value []byte) (err error) {
+	start := time.Now()
+	defer func() { s.observe("put", start, err) }()
+	return s.Store.Put(key, value)
+}
+
+// Delete ...
+func (s *instrumentedStore) Delete(key []byte) (err error) {
+	start := time.Now()
+	defer func() { s.observe("delete", start, err) }()
+	return s.Store.Delete(key)
+}
+
+// Scan ...
+func (s *instrumentedStore) Scan(prefix []byte, f func(key []byte) error) (err error) {
+	start := time.Now()
+	defer func() { s.observe("scan", start, err) }()
+	return s.Store.Scan(prefix, f)
+}
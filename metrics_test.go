@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingStore struct {
+	*MemoryStore
+}
+
+func (s failingStore) Put(key, value []byte) error {
+	return errors.New("disk full")
+}
+
+func TestMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	assert.Nil(SaveBookmark(NewBookmark("wiki", "https://wiki.example.com/?q={query}")))
+
+	s := newTestServer(t, Config{URL: DefaultURL})
+
+	commands := testutil.ToFloat64(resolutionsTotal.WithLabelValues(ResolveCommand))
+	bookmarks := testutil.ToFloat64(resolutionsTotal.WithLabelValues(ResolveBookmark))
+	fallbacks := testutil.ToFloat64(resolutionsTotal.WithLabelValues(ResolveFallback))
+	unknown := testutil.ToFloat64(resolutionsTotal.WithLabelValues(ResolveUnknown))
+	pings := testutil.ToFloat64(commandExecutionsTotal.WithLabelValues("ping", "ok"))
+	lists := testutil.ToFloat64(httpRequestsTotal.WithLabelValues("/list", "GET", "200"))
+
+	for _, path := range []string{"/?q=ping", "/wiki/foo", "/?q=asdf", "/asdf", "/list"} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		s.router.ServeHTTP(w, r)
+	}
+
+	assert.Equal(commands+1, testutil.ToFloat64(resolutionsTotal.WithLabelValues(ResolveCommand)))
+	assert.Equal(bookmarks+1, testutil.ToFloat64(resolutionsTotal.WithLabelValues(ResolveBookmark)))
+	assert.Equal(fallbacks+1, testutil.ToFloat64(resolutionsTotal.WithLabelValues(ResolveFallback)))
+	assert.Equal(unknown+1, testutil.ToFloat64(resolutionsTotal.WithLabelValues(ResolveUnknown)))
+	assert.Equal(pings+1, testutil.ToFloat64(commandExecutionsTotal.WithLabelValues("ping", "ok")))
+	assert.Equal(lists+1, testutil.ToFloat64(httpRequestsTotal.WithLabelValues("/list", "GET", "200")))
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/metrics", nil)
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+
+	body := w.Body.String()
+	assert.Contains(body, `golinks_http_requests_total{code="404",handler="shortlink",method="GET"}`)
+	assert.Contains(body, `golinks_http_request_duration_seconds_bucket{handler="/",le="+Inf"}`)
+	assert.Contains(body, `golinks_resolutions_total{type="bookmark"}`)
+	assert.Contains(body, `golinks_command_executions_total{command="ping",result="ok"}`)
+	assert.Contains(body, "go_goroutines")
+}
+
+func TestInstrumentStore(t *testing.T) {
+	assert := assert.New(t)
+
+	store, err := OpenStore("memory", "")
+	assert.Nil(err)
+	defer store.Close()
+
+	gets := testutil.ToFloat64(storeOperationErrorsTotal.WithLabelValues("memory", "get"))
+
+	_, err = store.Get([]byte("foo"))
+	assert.Equal(ErrKeyNotFound, err)
+	assert.Nil(store.Put([]byte("foo"), []byte("bar")))
+
+	// Missing keys are not errors
+	assert.Equal(gets, testutil.ToFloat64(storeOperationErrorsTotal.WithLabelValues("memory", "get")))
+
+	puts := testutil.ToFloat64(storeOperationErrorsTotal.WithLabelValues("failing", "put"))
+	failing := InstrumentStore("failing", failingStore{NewMemoryStore()})
+	assert.NotNil(failing.Put([]byte("foo"), []byte("bar")))
+	assert.Equal(puts+1, testutil.ToFloat64(storeOperationErrorsTotal.WithLabelValues("failing", "put")))
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/metrics", nil)
+	MetricsHandler().ServeHTTP(w, r)
+	assert.Contains(w.Body.String(), `golinks_store_operation_duration_seconds_count{op="put",store="memory"}`)
+}
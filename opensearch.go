@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// DefaultDescription is the default description of the search engine
+	DefaultDescription = "Smart bookmarks"
+
+	// DefaultInputEncoding is the default character encoding of search
+	// terms sent by browsers
+	DefaultInputEncoding = "UTF-8"
+
+	// MaxShortNameLength is the maximum length of the short name of a search
+	// engine allowed by the OpenSearch specification
+	MaxShortNameLength = 16
+)
+
+// OpenSearchTemplate is the OpenSearch description document of a search
+// engine (see https://github.com/dewitt/opensearch), all values are escaped
+// with EscapeXML
+const OpenSearchTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<OpenSearchDescription xmlns="http://a9.com/-/spec/opensearch/1.1/" xmlns:moz="http://www.mozilla.org/2006/browser/search/">
+  <ShortName>{{ xml .ShortName }}</ShortName>
+  <Description>{{ xml .Description }}</Description>
+  <Tags>{{ xml .Tags }}</Tags>
+{{- if .Contact }}
+  <Contact>{{ xml .Contact }}</Contact>
+{{- end }}
+{{- if .Image }}
+  <Image width="16" height="16"{{ if .ImageType }} type="{{ xml .ImageType }}"{{ end }}>{{ xml .Image }}</Image>
+{{- end }}
+  <InputEncoding>{{ xml .InputEncoding }}</InputEncoding>
+  <Url type="text/html" method="get" template="{{ xml .SearchURL }}"/>
+  <Url type="application/x-suggestions+json" method="get" template="{{ xml .SuggestURL }}"/>
+  <Url type="application/opensearchdescription+xml" rel="self" template="{{ xml .SelfURL }}"/>
+  <moz:SearchForm>{{ xml .SearchForm }}</moz:SearchForm>
+</OpenSearchDescription>
+`
+
+var openSearchTemplate = template.Must(
+	template.New("opensearch").
+		Funcs(template.FuncMap{"xml": EscapeXML}).
+		Parse(OpenSearchTemplate),
+)
+
+// OpenSearchDescription describes a search engine for browsers to install
+type OpenSearchDescription struct {
+	ShortName   string
+	Description string
+	Tags        string
+	Contact     string
+
+	// InputEncoding is the character encoding of the search terms
+	InputEncoding string
+
+	// Image is the URL of the icon and ImageType its media type, if known
+	Image     string
+	ImageType string
+
+	// SearchURL and SuggestURL are URL templates with {searchTerms} for the
+	// search terms, SelfURL is where the description is served and
+	// SearchForm the page to search from
+	SearchURL  string
+	SuggestURL string
+	SelfURL    string
+	SearchForm string
+}
+
+// Render renders the description as XML
+func (d OpenSearchDescription) Render() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := openSearchTemplate.Execute(buf, d); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// shortName truncates name to the maximum length of a short name
+func shortName(name string) string {
+	runes := []rune(name)
+	if len(runes) > MaxShortNameLength {
+		runes = runes[:MaxShortNameLength]
+	}
+	return string(runes)
+}
+
+// imageType returns the media type of the image at rawurl from its
+// extension, or "" if unknown
+func imageType(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	ext := strings.ToLower(path.Ext(u.Path))
+	if ext == ".ico" {
+		// Not known to the mime package on all systems
+		return "image/x-icon"
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// OpenSearchDescription returns the description of the search engine served
+// at base (the scheme and host clients reach the server at)
+func (s *Server) OpenSearchDescription(base string) OpenSearchDescription {
+	d := OpenSearchDescription{
+		ShortName:   shortName(s.config.Title),
+		Description: s.config.Description,
+		Tags:        "search",
+		Contact:     s.config.Contact,
+
+		InputEncoding: s.config.InputEncoding,
+
+		SearchURL:  base + "/?q={searchTerms}",
+		SuggestURL: base + "/suggest?q={searchTerms}",
+		SelfURL:    base + "/opensearch.xml",
+		SearchForm: base + "/",
+	}
+	if d.Description == "" {
+		d.Description = DefaultDescription
+	}
+	if d.InputEncoding == "" {
+		d.InputEncoding = DefaultInputEncoding
+	}
+
+	if s.config.Favicon != "" {
+		d.Image = s.config.Favicon
+		if strings.HasPrefix(d.Image, "/") && !strings.HasPrefix(d.Image, "//") {
+			d.Image = base + d.Image
+		}
+		d.ImageType = imageType(d.Image)
+	}
+
+	return d
+}
+
+// BookmarkOpenSearchDescription returns the description of a search engine
+// for the bookmark bookmark, reached as name (its name or an alias of it),
+// where the search terms are the bookmark's arguments
+func (s *Server) BookmarkOpenSearchDescription(base, name string, bookmark Bookmark) OpenSearchDescription {
+	d := s.OpenSearchDescription(base)
+
+	d.ShortName = shortName(name)
+	if bookmark.Desc() != "" {
+		d.Description = bookmark.Desc()
+	} else {
+		d.Description = name + " via " + s.config.Title
+	}
+	if len(bookmark.Tags()) > 0 {
+		d.Tags = strings.Join(bookmark.Tags(), " ")
+	}
+
+	prefix := url.QueryEscape(name)
+	d.SearchURL = base + "/?q=" + prefix + "+{searchTerms}"
+	d.SuggestURL = base + "/suggest?bookmark=" + prefix + "&q={searchTerms}"
+	d.SelfURL = base + "/opensearch/" + url.PathEscape(name) + ".xml"
+
+	return d
+}
+
+func writeOpenSearchDescription(w http.ResponseWriter, d OpenSearchDescription) {
+	data, err := d.Render()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/opensearchdescription+xml; charset=utf-8")
+	w.Write(data)
+}
+
+// OpenSearchHandler serves the OpenSearch description of golinks itself so
+// browsers can add it as a search engine
+func (s *Server) OpenSearchHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		s.counters.Inc("n_opensearch")
+
+		writeOpenSearchDescription(w, s.OpenSearchDescription(s.Scheme(r)+"://"+s.config.FQDN))
+	}
+}
+
+// BookmarkOpenSearchHandler serves /opensearch/<name>.xml, the OpenSearch
+// description of a single bookmark (or alias) so browsers can add it as a
+// search engine of its own
+func (s *Server) BookmarkOpenSearchHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		s.counters.Inc("n_opensearch")
+
+		name := p.ByName("name")
+		if !strings.HasSuffix(name, ".xml") {
+			http.NotFound(w, r)
+			return
+		}
+		name = strings.ToLower(strings.TrimSuffix(name, ".xml"))
+
+		target, err := ResolveAlias(name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		bookmark, ok := LookupBookmark(target)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		base := s.Scheme(r) + "://" + s.config.FQDN
+		writeOpenSearchDescription(w, s.BookmarkOpenSearchDescription(base, name, bookmark))
+	}
+}
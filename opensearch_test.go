@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenSearch(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newTestServer(t, Config{Title: "Search", FQDN: "localhost:8000"})
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/opensearch.xml", nil)
+	p := httprouter.Params{}
+
+	s.OpenSearchHandler()(w, r, p)
+	assert.Equal(w.Code, http.StatusOK)
+	assert.Equal("application/opensearchdescription+xml; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(w.Body.String(), "<OpenSearchDescription")
+	assert.Contains(w.Body.String(), "<Description>Smart bookmarks</Description>")
+	assert.Contains(w.Body.String(), "<InputEncoding>UTF-8</InputEncoding>")
+	assert.Contains(w.Body.String(), `template="http://localhost:8000/?q={searchTerms}"`)
+	assert.Contains(w.Body.String(), "<moz:SearchForm>http://localhost:8000/</moz:SearchForm>")
+	assert.NotContains(w.Body.String(), "<Contact>")
+	assert.NotContains(w.Body.String(), "<Image")
+
+	// Behind a proxy terminating TLS
+	w = httptest.NewRecorder()
+	r.Header.Set("X-Forwarded-Proto", "https")
+	s.OpenSearchHandler()(w, r, p)
+	assert.Contains(w.Body.String(), `template="https://localhost:8000/?q={searchTerms}"`)
+	assert.Contains(w.Body.String(), `template="https://localhost:8000/opensearch.xml"`)
+}
+
+func TestOpenSearchConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newTestServer(t, Config{
+		Title:       "Example <Corp> Search Engine",
+		FQDN:        "go.example.com",
+		Description: `Links & "bookmarks"`,
+		Contact:     "admin@example.com",
+		Favicon:     "/static/favicon.ico",
+
+		InputEncoding: "ISO-8859-1",
+	})
+
+	d := s.OpenSearchDescription("https://go.example.com")
+	assert.Equal("Example <Corp> S", d.ShortName)
+	assert.Equal("https://go.example.com/static/favicon.ico", d.Image)
+	assert.Equal("image/x-icon", d.ImageType)
+
+	data, err := d.Render()
+	assert.Nil(err)
+	assert.Contains(string(data), "<ShortName>Example &lt;Corp&gt; S</ShortName>")
+	assert.Contains(string(data), "<Description>Links &amp; &#34;bookmarks&#34;</Description>")
+	assert.Contains(string(data), "<Contact>admin@example.com</Contact>")
+	assert.Contains(string(data), "<InputEncoding>ISO-8859-1</InputEncoding>")
+	assert.Contains(string(data), `<Image width="16" height="16" type="image/x-icon">https://go.example.com/static/favicon.ico</Image>`)
+
+	// The description must be well formed with the values as given
+	var decoded struct {
+		ShortName   string
+		Description string
+		Urls        []struct {
+			Template string `xml:"template,attr"`
+		} `xml:"Url"`
+	}
+	assert.Nil(xml.Unmarshal(data, &decoded))
+	assert.Equal(d.ShortName, decoded.ShortName)
+	assert.Equal(`Links & "bookmarks"`, decoded.Description)
+	assert.Len(decoded.Urls, 3)
+
+	s.config.Favicon = "https://cdn.example.com/golinks.png"
+	d = s.OpenSearchDescription("https://go.example.com")
+	assert.Equal("https://cdn.example.com/golinks.png", d.Image)
+	assert.Equal("image/png", d.ImageType)
+}
+
+func TestBookmarkOpenSearch(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	wp := NewBookmark("wp", "https://en.wikipedia.org/wiki/Special:Search?search={query}")
+	wp.desc = "Wikipedia"
+	wp.tags = []string{"docs", "wiki"}
+	assert.Nil(SaveBookmark(wp))
+	assert.Nil(SaveBookmark(NewBookmark("gh", "https://github.com/search?q={query}")))
+	assert.Nil(SaveAlias(Alias{Name: "wiki", Target: "wp"}))
+
+	s := newTestServer(t, Config{Title: "Search", FQDN: "go.example.com"})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		s.router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/opensearch/wp.xml")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), "<ShortName>wp</ShortName>")
+	assert.Contains(w.Body.String(), "<Description>Wikipedia</Description>")
+	assert.Contains(w.Body.String(), "<Tags>docs wiki</Tags>")
+	assert.Contains(w.Body.String(), `template="http://go.example.com/?q=wp+{searchTerms}"`)
+	assert.Contains(w.Body.String(), `template="http://go.example.com/suggest?bookmark=wp&amp;q={searchTerms}"`)
+	assert.Contains(w.Body.String(), `template="http://go.example.com/opensearch/wp.xml"`)
+
+	w = get("/opensearch/gh.xml")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), "<Description>gh via Search</Description>")
+	assert.Contains(w.Body.String(), "<Tags>search</Tags>")
+
+	// Aliases keep their own name
+	w = get("/opensearch/wiki.xml")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), `template="http://go.example.com/?q=wiki+{searchTerms}"`)
+
+	for _, path := range []string{"/opensearch/foo.xml", "/opensearch/wp", "/opensearch/ping.xml"} {
+		assert.Equal(http.StatusNotFound, get(path).Code, path)
+	}
+
+	// Suggestions for the bookmark's search engine are for its arguments
+	suggestions := getSuggestions(t, s, "foo&bookmark=wp")
+	assert.Equal("foo", suggestions.Query)
+	assert.Equal([]string{"foo"}, suggestions.Completions)
+	assert.Equal([]string{"https://en.wikipedia.org/wiki/Special:Search?search=foo"}, suggestions.URLs)
+
+	suggestions = getSuggestions(t, s, "&bookmark=wp")
+	assert.Equal(0, suggestions.Len())
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// SeedMissing only adds bookmarks from the seed file that don't exist
+	SeedMissing = "missing"
+	// SeedAuthoritative makes the store match the seed file exactly, adding,
+	// updating and deleting bookmarks as needed
+	SeedAuthoritative = "authoritative"
+
+	// SeedUser is the user recorded in the history of changes made by
+	// syncing the seed file
+	SeedUser = "seed"
+
+	// DefaultSeedInterval is how often the seed file is checked for changes
+	DefaultSeedInterval = 10 * time.Second
+)
+
+// SeedBookmark is a bookmark in a seed file
+type SeedBookmark struct {
+	Name string   `yaml:"name" json:"name"`
+	URL  string   `yaml:"url" json:"url"`
+	Desc string   `yaml:"desc" json:"desc"`
+	Tags []string `yaml:"tags" json:"tags"`
+
+	Completions     []string `yaml:"completions" json:"completions"`
+	CompletionsFile string   `yaml:"completions_file" json:"completions_file"`
+
+	SuggestURL    string `yaml:"suggest_url" json:"suggest_url"`
+	SuggestFormat string `yaml:"suggest_format" json:"suggest_format"`
+}
+
+// SeedFile is a declarative list of bookmarks, for example:
+//
+//	bookmarks:
+//	  - name: wiki
+//	    url: https://wiki.example.com/?q={query}
+//	    desc: Team wiki
+//	    tags: [docs]
+type SeedFile struct {
+	Bookmarks []SeedBookmark `yaml:"bookmarks" json:"bookmarks"`
+}
+
+// SyncReport is the outcome of syncing a seed file
+type SyncReport struct {
+	Created int
+	Updated int
+	Deleted int
+}
+
+func (r SyncReport) String() string {
+	return fmt.Sprintf("%d created, %d updated, %d deleted", r.Created, r.Updated, r.Deleted)
+}
+
+// ReadSeedFile reads a seed file, as JSON if it has a .json extension and
+// as YAML otherwise
+func ReadSeedFile(path string) (SeedFile, error) {
+	var seed SeedFile
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return seed, err
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &seed)
+	} else {
+		err = yaml.UnmarshalStrict(data, &seed)
+	}
+	if err != nil {
+		return seed, fmt.Errorf("error parsing seed file %s: %s", path, err)
+	}
+
+	return seed, nil
+}
+
+// Validate checks all bookmarks in the seed file, returning the first
+// invalid or duplicate one as an error
+func (f SeedFile) Validate() error {
+	seen := make(map[string]bool)
+	for i, b := range f.Bookmarks {
+		name := strings.ToLower(b.Name)
+		if err := ValidateName(name); err != nil {
+			return fmt.Errorf("bookmark #%d: %s", i+1, err)
+		}
+		if err := ValidateURL(b.URL); err != nil {
+			return fmt.Errorf("bookmark %s: %s", name, err)
+		}
+		if err := ValidateCompletionsFile(b.CompletionsFile); err != nil {
+			return fmt.Errorf("bookmark %s: %s", name, err)
+		}
+		if err := ValidateSuggestURL(b.SuggestURL); err != nil {
+			return fmt.Errorf("bookmark %s: %s", name, err)
+		}
+		if err := ValidateSuggestFormat(b.SuggestFormat); err != nil {
+			return fmt.Errorf("bookmark %s: %s", name, err)
+		}
+		if seen[name] {
+			return fmt.Errorf("bookmark %s: duplicate name", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// same reports whether bookmark already matches the seed bookmark b
+func (b SeedBookmark) same(bookmark Bookmark) bool {
+	return bookmark.url == b.URL &&
+		bookmark.desc == b.Desc &&
+		sameTags(bookmark.tags, b.Tags) &&
+		sameTags(bookmark.completions, b.Completions) &&
+		bookmark.completionsFile == b.CompletionsFile &&
+		bookmark.suggestURL == b.SuggestURL &&
+		bookmark.suggestFormat == b.SuggestFormat
+}
+
+func sameTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// SyncSeedFile reconciles the bookmarks in the store with the seed file.
+// Nothing is changed if any bookmark in the file is invalid. In SeedMissing
+// mode only bookmarks that don't exist are added, in SeedAuthoritative mode
+// existing bookmarks are also updated to match the file and any bookmarks
+// not in the file are deleted.
+func SyncSeedFile(seed SeedFile, mode string) (report SyncReport, err error) {
+	if mode != SeedMissing && mode != SeedAuthoritative {
+		return report, fmt.Errorf("invalid seed mode: %s", mode)
+	}
+	if err := seed.Validate(); err != nil {
+		return report, err
+	}
+
+	names := make(map[string]bool)
+	for _, b := range seed.Bookmarks {
+		name := strings.ToLower(b.Name)
+		names[name] = true
+
+		bookmark, ok := LookupBookmark(name)
+		switch {
+		case !ok:
+			bookmark = NewBookmark(name, b.URL)
+			report.Created++
+		case mode == SeedMissing:
+			continue
+		case b.same(bookmark):
+			continue
+		default:
+			bookmark.url = b.URL
+			bookmark.updated = time.Now()
+			report.Updated++
+		}
+		bookmark.desc = b.Desc
+		bookmark.tags = b.Tags
+		bookmark.completions = b.Completions
+		bookmark.completionsFile = b.CompletionsFile
+		bookmark.suggestURL = b.SuggestURL
+		bookmark.suggestFormat = b.SuggestFormat
+
+		if err := SaveBookmarkBy(SeedUser, bookmark); err != nil {
+			return report, err
+		}
+	}
+
+	if mode == SeedAuthoritative {
+		bookmarks, err := ListBookmarks()
+		if err != nil {
+			return report, err
+		}
+		for _, bookmark := range bookmarks {
+			if names[bookmark.name] {
+				continue
+			}
+			if err := DeleteBookmarkBy(SeedUser, bookmark.name); err != nil {
+				return report, err
+			}
+			report.Deleted++
+		}
+	}
+
+	return report, nil
+}
+
+// SeedWatcher syncs a seed file into the store whenever it changes
+type SeedWatcher struct {
+	path     string
+	mode     string
+	interval time.Duration
+
+	modTime time.Time
+	size    int64
+}
+
+// NewSeedWatcher returns a watcher for the seed file at path checking for
+// changes every interval
+func NewSeedWatcher(path, mode string, interval time.Duration) *SeedWatcher {
+	return &SeedWatcher{path: path, mode: mode, interval: interval}
+}
+
+// Sync syncs the seed file if it changed since the last sync, reporting
+// whether it did
+func (w *SeedWatcher) Sync() (bool, error) {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+		return false, nil
+	}
+	w.modTime, w.size = info.ModTime(), info.Size()
+
+	seed, err := ReadSeedFile(w.path)
+	if err != nil {
+		return false, err
+	}
+
+	report, err := SyncSeedFile(seed, w.mode)
+	if err != nil {
+		return false, err
+	}
+
+	log.Printf("synced seed file %s: %s", w.path, report)
+	return true, nil
+}
+
+// Run checks the seed file for changes every interval until stop is closed
+func (w *SeedWatcher) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := w.Sync(); err != nil {
+				log.Printf("error syncing seed file: %s", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeSeedFile(t *testing.T, dir, name, data string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadSeedFile(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "golinks")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	seed, err := ReadSeedFile(writeSeedFile(t, dir, "seed.yml", `
+bookmarks:
+  - name: wiki
+    url: https://wiki.example.com/?q={query}
+    desc: Team wiki
+    tags: [docs, team]
+`))
+	assert.Nil(err)
+	assert.Equal([]SeedBookmark{{
+		Name: "wiki",
+		URL:  "https://wiki.example.com/?q={query}",
+		Desc: "Team wiki",
+		Tags: []string{"docs", "team"},
+	}}, seed.Bookmarks)
+
+	seed, err = ReadSeedFile(writeSeedFile(t, dir, "seed.json",
+		`{"bookmarks": [{"name": "wiki", "url": "https://wiki.example.com/"}]}`,
+	))
+	assert.Nil(err)
+	assert.Len(seed.Bookmarks, 1)
+	assert.Equal("https://wiki.example.com/", seed.Bookmarks[0].URL)
+
+	_, err = ReadSeedFile(writeSeedFile(t, dir, "typo.yml", `
+bookmarks:
+  - name: wiki
+    link: https://wiki.example.com/
+`))
+	assert.Error(err)
+
+	_, err = ReadSeedFile(filepath.Join(dir, "missing.yml"))
+	assert.Error(err)
+}
+
+func TestSyncSeedFileMissing(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	assert.Nil(SaveBookmark(NewBookmark("wiki", "https://wiki.example.com/")))
+
+	seed := SeedFile{Bookmarks: []SeedBookmark{
+		{Name: "wiki", URL: "https://en.wikipedia.org/"},
+		{Name: "GH", URL: "https://github.com/{owner}/{repo}", Tags: []string{"code"}},
+	}}
+
+	report, err := SyncSeedFile(seed, SeedMissing)
+	assert.Nil(err)
+	assert.Equal(SyncReport{Created: 1}, report)
+
+	bookmark, ok := LookupBookmark("wiki")
+	assert.True(ok)
+	assert.Equal("https://wiki.example.com/", bookmark.URL())
+
+	bookmark, ok = LookupBookmark("gh")
+	assert.True(ok)
+	assert.Equal("https://github.com/{owner}/{repo}", bookmark.URL())
+	assert.Equal([]string{"code"}, bookmark.Tags())
+
+	revisions, err := ListRevisions("gh")
+	assert.Nil(err)
+	assert.Len(revisions, 1)
+	assert.Equal(SeedUser, revisions[0].User)
+
+	report, err = SyncSeedFile(seed, SeedMissing)
+	assert.Nil(err)
+	assert.Equal(SyncReport{}, report)
+}
+
+func TestSyncSeedFileAuthoritative(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	assert.Nil(SaveBookmark(NewBookmark("wiki", "https://wiki.example.com/")))
+	assert.Nil(SaveBookmark(NewBookmark("old", "https://old.example.com/")))
+	assert.Nil(SaveBookmark(NewBookmark("same", "https://same.example.com/")))
+
+	seed := SeedFile{Bookmarks: []SeedBookmark{
+		{Name: "wiki", URL: "https://en.wikipedia.org/", Desc: "Wikipedia"},
+		{Name: "same", URL: "https://same.example.com/"},
+		{Name: "gh", URL: "https://github.com/"},
+	}}
+
+	report, err := SyncSeedFile(seed, SeedAuthoritative)
+	assert.Nil(err)
+	assert.Equal(SyncReport{Created: 1, Updated: 1, Deleted: 1}, report)
+
+	bookmark, ok := LookupBookmark("wiki")
+	assert.True(ok)
+	assert.Equal("https://en.wikipedia.org/", bookmark.URL())
+	assert.Equal("Wikipedia", bookmark.Desc())
+
+	_, ok = LookupBookmark("old")
+	assert.False(ok)
+	_, ok = LookupBookmark("gh")
+	assert.True(ok)
+
+	revisions, err := ListRevisions("same")
+	assert.Nil(err)
+	assert.Len(revisions, 0)
+
+	report, err = SyncSeedFile(seed, SeedAuthoritative)
+	assert.Nil(err)
+	assert.Equal(SyncReport{}, report)
+}
+
+func TestSyncSeedFileInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	assert.Nil(SaveBookmark(NewBookmark("wiki", "https://wiki.example.com/")))
+
+	for _, seed := range []SeedFile{
+		{Bookmarks: []SeedBookmark{
+			{Name: "gh", URL: "https://github.com/"},
+			{Name: "help", URL: "https://example.com/"},
+		}},
+		{Bookmarks: []SeedBookmark{
+			{Name: "gh", URL: "https://github.com/"},
+			{Name: "bad", URL: "not a url"},
+		}},
+		{Bookmarks: []SeedBookmark{
+			{Name: "gh", URL: "https://github.com/"},
+			{Name: "GH", URL: "https://github.com/"},
+		}},
+		{Bookmarks: []SeedBookmark{
+			{Name: "gh", URL: "https://github.com/", SuggestFormat: "$.["},
+		}},
+	} {
+		_, err := SyncSeedFile(seed, SeedAuthoritative)
+		assert.Error(err)
+	}
+
+	_, err := SyncSeedFile(SeedFile{}, "sometimes")
+	assert.Error(err)
+
+	bookmarks, err := ListBookmarks()
+	assert.Nil(err)
+	assert.Len(bookmarks, 1)
+	assert.Equal("wiki", bookmarks[0].Name())
+}
+
+func TestSeedWatcher(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	dir, err := ioutil.TempDir("", "golinks")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	path := writeSeedFile(t, dir, "seed.yml", `
+bookmarks:
+  - name: wiki
+    url: https://wiki.example.com/
+`)
+
+	watcher := NewSeedWatcher(path, SeedAuthoritative, time.Second)
+
+	synced, err := watcher.Sync()
+	assert.Nil(err)
+	assert.True(synced)
+	_, ok := LookupBookmark("wiki")
+	assert.True(ok)
+
+	synced, err = watcher.Sync()
+	assert.Nil(err)
+	assert.False(synced)
+
+	writeSeedFile(t, dir, "seed.yml", `
+bookmarks:
+  - name: gh
+    url: https://github.com/
+`)
+	later := time.Now().Add(time.Minute)
+	assert.Nil(os.Chtimes(path, later, later))
+
+	synced, err = watcher.Sync()
+	assert.Nil(err)
+	assert.True(synced)
+	_, ok = LookupBookmark("wiki")
+	assert.False(ok)
+	_, ok = LookupBookmark("gh")
+	assert.True(ok)
+}
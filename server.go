@@ -1,14 +1,15 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"html/template"
-	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
-	"sort"
 	"strings"
 	"time"
 
@@ -29,6 +30,20 @@ var (
 	client = &http.Client{
 		Timeout: 5 * time.Second,
 	}
+
+	// suggestClient fetches suggestions from the services of bookmarks,
+	// which users choose, so it only connects to public addresses (see
+	// dialPublic) and not through a proxy that would connect anywhere
+	suggestClient = &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: 5 * time.Second,
+				Control: dialPublic,
+			}).DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+	}
 )
 
 // Counters ...
@@ -61,10 +76,23 @@ func (c *Counters) DecBy(name string, n int64) {
 
 // Server ...
 type Server struct {
-	bind      string
-	config    Config
+	bind     string
+	config   Config
+	server   *http.Server
+	redirect *http.Server
+	certs    *CertReloader
+
+	// Upstream suggestions
+	suggestCache   *SuggestCache
+	suggestGroup   suggestGroup
+	suggestLimiter *ClientLimiter
+
+	// Reverse proxies trusted to set X-Forwarded-For
+	trustedProxies []*net.IPNet
+
 	templates *Templates
 	router    *httprouter.Router
+	auth      *Auth
 
 	// Logger
 	logger *logger.Logger
@@ -114,34 +142,98 @@ func (s *Server) IndexHandler() httprouter.Handle {
 			}
 		} else {
 			cmd = p.ByName("command")
-			args = strings.Split(p.ByName("args"), "/")
+			args = splitPathArgs(p.ByName("args"))
+		}
+
+		if cmd != "" {
+			target, err := ResolveAlias(cmd)
+			if err != nil {
+				http.Error(w, err.Error(), ErrorStatus(err))
+				return
+			}
+			cmd = target
 		}
 
 		if cmd == "" {
-			s.render("index", w, nil)
+			token, err := CSRFToken(w, r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			s.render("index", w, map[string]interface{}{
+				"CSRFToken": token,
+			})
 		} else {
 			if command := LookupCommand(cmd); command != nil {
+				resolutionsTotal.WithLabelValues(ResolveCommand).Inc()
+
+				if !command.ReadOnly() && !ValidCSRF(r) {
+					s.confirm(w, r, command, args)
+					return
+				}
+
 				err := command.Exec(w, r, args)
+				result := "ok"
 				if err != nil {
+					result = "error"
+				}
+				commandExecutionsTotal.WithLabelValues(command.Name(), result).Inc()
+
+				if err == nil {
+					if err := RecordUsage(UsageCommand, command.Name(), time.Now()); err != nil {
+						log.Printf("error recording usage of %s: %s", command.Name(), err)
+					}
+				} else if err == ErrUnauthorized {
+					s.auth.Challenge(w, err.Error())
+				} else if err != nil {
 					http.Error(
 						w,
 						fmt.Sprintf(
 							"Error processing command %s: %s",
 							command.Name(), err,
 						),
-						http.StatusInternalServerError,
+						ErrorStatus(err),
 					)
 				}
 			} else if bookmark, ok := LookupBookmark(cmd); ok {
+				resolutionsTotal.WithLabelValues(ResolveBookmark).Inc()
+
 				q := strings.Join(args, " ")
-				bookmark.Exec(w, r, q)
+				if err := bookmark.Exec(w, r, q); err != nil {
+					http.Error(
+						w,
+						fmt.Sprintf(
+							"Error processing bookmark %s: %s",
+							bookmark.Name(), err,
+						),
+						ErrorStatus(err),
+					)
+					return
+				}
+				if err := HitBookmark(bookmark); err != nil {
+					log.Printf("error updating hits for %s: %s", cmd, err)
+				}
 			} else {
-				if s.config.URL != "" {
-					url := s.config.URL
-					if q != "" {
-						url = fmt.Sprintf(url, q)
+				if s.config.Fallback == FallbackDidYouMean || q == "" || s.config.URL == "" {
+					resolutionsTotal.WithLabelValues(ResolveUnknown).Inc()
+				} else {
+					resolutionsTotal.WithLabelValues(ResolveFallback).Inc()
+				}
+
+				if s.config.Fallback == FallbackDidYouMean {
+					s.didYouMean(w, r, cmd, args)
+				} else if q == "" {
+					// Unknown path based short links e.g. /foo/bar
+					http.Error(
+						w,
+						fmt.Sprintf("Not Found: %v", cmd),
+						http.StatusNotFound,
+					)
+				} else if s.config.URL != "" {
+					fallback := NewBookmark("", s.config.URL)
+					if err := fallback.Exec(w, r, q); err != nil {
+						http.Error(w, err.Error(), ErrorStatus(err))
 					}
-					http.Redirect(w, r, url, http.StatusFound)
 				} else {
 					http.Error(
 						w,
@@ -154,94 +246,80 @@ func (s *Server) IndexHandler() httprouter.Handle {
 	}
 }
 
-// HelpHandler ...
-func (s *Server) HelpHandler() httprouter.Handle {
-	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-		s.counters.Inc("n_help")
+// confirm renders a page asking the user to confirm running a command that
+// makes changes, as it was not submitted from one of our own forms
+func (s *Server) confirm(w http.ResponseWriter, r *http.Request, command Command, args []string) {
+	s.counters.Inc("n_confirm")
 
-		s.render("help", w, nil)
+	token, err := CSRFToken(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-}
 
-// ListHandler ...
-func (s *Server) ListHandler() httprouter.Handle {
-	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-		s.counters.Inc("n_list")
+	var preview string
+	if previewer, ok := command.(Previewer); ok {
+		preview = previewer.Preview(args)
+	}
 
-		var (
-			bk  []Bookmark
-			cmd []Command
-		)
+	s.render("confirm", w, map[string]interface{}{
+		"Command":   command,
+		"Query":     strings.Join(append([]string{command.Name()}, args...), " "),
+		"Preview":   preview,
+		"CSRFToken": token,
+	})
+}
 
-		prefix := []byte("bookmark_")
-		err := db.Scan(prefix, func(key []byte) error {
-			val, err := db.Get(key)
-			if err != nil {
-				return err
-			}
-			name := strings.TrimPrefix(string(key), "bookmark_")
-			bk = append(bk, Bookmark{name, string(val)})
-			return nil
-		})
+// splitPathArgs splits the escaped path args/of/a/short/link into its
+// unescaped non-empty segments
+func splitPathArgs(path string) (args []string) {
+	for _, segment := range strings.Split(path, "/") {
+		arg, err := url.PathUnescape(segment)
 		if err != nil {
-			log.Printf("error reading list of bookmarks: %s", err)
-		}
-
-		var names []string
-		for k := range commands {
-			names = append(names, k)
+			arg = segment
 		}
-		sort.Sort(sort.StringSlice(names))
-		for _, name := range names {
-			cmd = append(cmd, commands[name])
+		if arg != "" {
+			args = append(args, arg)
 		}
-
-		data := map[string]interface{}{
-			"Bookmarks": bk,
-			"Commands":  cmd,
-		}
-		s.render("list", w, data)
-	}
-}
-
-// OpenSearchHandler ...
-func (s *Server) OpenSearchHandler() httprouter.Handle {
-	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-		s.counters.Inc("n_opensearch")
-
-		w.Header().Set("Content-Type", "text/xml")
-		w.Write(
-			[]byte(fmt.Sprintf(
-				OpenSearchTemplate,
-				s.config.Title,
-				s.config.FQDN,
-				s.config.FQDN,
-			)),
-		)
 	}
+	return
 }
 
-// SuggestionsHandler ...
-func (s *Server) SuggestionsHandler() httprouter.Handle {
-	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-		// Query ?q=
-		q := r.URL.Query().Get("q")
-		resp, err := client.Get(fmt.Sprintf(s.config.SuggestURL, url.QueryEscape(q)))
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+// ShortLinkHandler resolves path based short links such as /wiki/foo/bar
+// for paths that don't match any other route. The first segment is the
+// bookmark or command name and any others are its arguments.
+func (s *Server) ShortLinkHandler() http.Handler {
+	index := s.IndexHandler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.NotFound(w, r)
 			return
 		}
-		defer resp.Body.Close()
-		if resp.StatusCode > 200 {
-			http.Error(w, "request failed", resp.StatusCode)
+
+		s.counters.Inc("n_shortlink")
+
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/", 2)
+		name, err := url.PathUnescape(parts[0])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		if _, err := io.Copy(w, resp.Body); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		p := httprouter.Params{{Key: "command", Value: name}}
+		if len(parts) == 2 {
+			p = append(p, httprouter.Param{Key: "args", Value: parts[1]})
 		}
+
+		index(w, r, p)
+	})
+}
+
+// HelpHandler ...
+func (s *Server) HelpHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		s.counters.Inc("n_help")
+
+		s.render("help", w, nil)
 	}
 }
 
@@ -257,36 +335,116 @@ func (s *Server) StatsHandler() httprouter.Handle {
 	}
 }
 
-// ListenAndServe ...
-func (s *Server) ListenAndServe() {
-	log.Fatal(
-		http.ListenAndServe(
-			s.bind,
-			s.logger.Handler(
-				s.stats.Handler(
-					gziphandler.GzipHandler(
-						s.router,
-					),
+// Handler returns the router wrapped in logging, stats, compression and
+// authentication
+func (s *Server) Handler() http.Handler {
+	return s.logger.Handler(
+		s.stats.Handler(
+			gziphandler.GzipHandler(
+				s.auth.Handler(
+					s.router,
 				),
 			),
 		),
 	)
 }
 
+// ListenAndServe serves requests on the bind address, over TLS if a
+// certificate is configured, until Shutdown is called, after which it
+// returns http.ErrServerClosed. Plain HTTP requests to the redirect address
+// are redirected to HTTPS.
+func (s *Server) ListenAndServe() error {
+	if s.redirect != nil {
+		l, err := net.Listen("tcp", s.redirect.Addr)
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := s.redirect.Serve(l); err != http.ErrServerClosed {
+				log.Printf("error serving HTTPS redirects: %s", err)
+			}
+		}()
+	}
+
+	if s.certs != nil {
+		return s.server.ListenAndServeTLS("", "")
+	}
+	return s.server.ListenAndServe()
+}
+
+// Serve is ListenAndServe for an existing listener
+func (s *Server) Serve(l net.Listener) error {
+	if s.certs != nil {
+		return s.server.ServeTLS(l, "", "")
+	}
+	return s.server.Serve(l)
+}
+
+// Shutdown stops accepting new connections and waits for in-flight requests
+// to finish or for ctx to expire, whichever comes first
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.redirect != nil {
+		if err := s.redirect.Shutdown(ctx); err != nil {
+			log.Printf("error shutting down HTTPS redirects: %s", err)
+		}
+	}
+	return s.server.Shutdown(ctx)
+}
+
+// Scheme returns the scheme clients use to reach the server: https if it
+// serves TLS itself or r came through a proxy terminating TLS
+func (s *Server) Scheme(r *http.Request) string {
+	if s.certs != nil || r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		return "https"
+	}
+	return "http"
+}
+
+// handle registers a route instrumented with metrics labelled by its path
+func (s *Server) handle(method, path string, h httprouter.Handle) {
+	s.router.Handle(method, path, instrumentHandle(path, h))
+}
+
 func (s *Server) initRoutes() {
-	s.router.Handler("GET", "/debug/metrics", exp.ExpHandler(s.counters.r))
-	s.router.GET("/debug/stats", s.StatsHandler())
-
-	s.router.GET("/", s.IndexHandler())
-	s.router.POST("/", s.IndexHandler())
-	s.router.GET("/help", s.HelpHandler())
-	s.router.GET("/list", s.ListHandler())
-	s.router.GET("/opensearch.xml", s.OpenSearchHandler())
-	s.router.GET("/suggest", s.SuggestionsHandler())
+	s.router.Handler("GET", "/debug/metrics", instrumentHandler("/debug/metrics", exp.ExpHandler(s.counters.r)))
+	s.router.Handler("GET", "/metrics", MetricsHandler())
+	s.handle("GET", "/debug/stats", s.StatsHandler())
+
+	s.handle("GET", "/", s.IndexHandler())
+	s.handle("POST", "/", s.IndexHandler())
+	s.handle("GET", "/help", s.HelpHandler())
+	s.handle("GET", "/list", s.ListHandler())
+	s.handle("GET", "/stats", s.UsageHandler())
+	s.handle("GET", "/history/:name", s.HistoryHandler())
+	s.handle("GET", "/opensearch.xml", s.OpenSearchHandler())
+	s.handle("GET", "/opensearch/:name", s.BookmarkOpenSearchHandler())
+	s.handle("GET", "/suggest", s.SuggestionsHandler())
+	s.handle("GET", "/export", s.ExportHandler())
+	s.handle("POST", "/import", s.ImportHandler())
+
+	s.handle("GET", "/bookmarks/new", s.NewBookmarkHandler())
+	s.handle("POST", "/bookmarks/new", s.NewBookmarkHandler())
+	s.handle("GET", "/bookmarks/edit/:name", s.EditBookmarkHandler())
+	s.handle("POST", "/bookmarks/edit/:name", s.EditBookmarkHandler())
+	s.handle("GET", "/bookmarks/delete/:name", s.DeleteBookmarkHandler())
+	s.handle("POST", "/bookmarks/delete/:name", s.DeleteBookmarkHandler())
+
+	s.handle("GET", "/api/v1/bookmarks", s.APIListBookmarksHandler())
+	s.handle("POST", "/api/v1/bookmarks", s.APICreateBookmarkHandler())
+	s.handle("GET", "/api/v1/bookmarks/:name", s.APIGetBookmarkHandler())
+	s.handle("PUT", "/api/v1/bookmarks/:name", s.APIUpdateBookmarkHandler())
+	s.handle("DELETE", "/api/v1/bookmarks/:name", s.APIDeleteBookmarkHandler())
+	s.handle("GET", "/api/v1/links", s.APIListLinksHandler())
+	s.handle("GET", "/api/v1/usage", s.APIListUsageHandler())
+	s.handle("GET", "/api/v1/usage/:kind/:name", s.APIGetUsageHandler())
+
+	// Everything else is a short link e.g. /wiki/foo/bar
+	s.router.NotFound = instrumentHandler("shortlink", s.ShortLinkHandler())
 }
 
-// NewServer ...
-func NewServer(bind string, config Config) *Server {
+// NewServer returns a server listening on bind with the given config, or an
+// error if authentication or TLS can't be set up from it
+func NewServer(bind string, config Config) (*Server, error) {
 	server := &Server{
 		bind:      bind,
 		config:    config,
@@ -305,6 +463,27 @@ func NewServer(bind string, config Config) *Server {
 		stats:    stats.New(),
 	}
 
+	// Upstream suggestions
+	if config.SuggestCacheSize > 0 && config.SuggestCacheTTL > 0 {
+		server.suggestCache = NewSuggestCache(config.SuggestCacheSize, config.SuggestCacheTTL)
+	}
+	if config.SuggestRate > 0 {
+		server.suggestLimiter = NewClientLimiter(config.SuggestRate, config.SuggestBurst)
+	}
+
+	trusted, err := ParseNetworks(config.AuthProxyTrusted)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing trusted proxy networks: %s", err)
+	}
+	server.trustedProxies = trusted
+
+	// Authentication
+	auth, err := NewAuth(config)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring authentication: %s", err)
+	}
+	server.auth = auth
+
 	// Templates
 	box := rice.MustFindBox("templates")
 
@@ -316,6 +495,30 @@ func NewServer(bind string, config Config) *Server {
 	template.Must(helpTemplate.Parse(box.MustString("help.html")))
 	template.Must(helpTemplate.Parse(box.MustString("base.html")))
 
+	confirmTemplate := template.New("confirm")
+	template.Must(confirmTemplate.Parse(box.MustString("confirm.html")))
+	template.Must(confirmTemplate.Parse(box.MustString("base.html")))
+
+	didYouMeanTemplate := template.New("didyoumean")
+	template.Must(didYouMeanTemplate.Parse(box.MustString("didyoumean.html")))
+	template.Must(didYouMeanTemplate.Parse(box.MustString("base.html")))
+
+	bookmarkTemplate := template.New("bookmark")
+	template.Must(bookmarkTemplate.Parse(box.MustString("bookmark.html")))
+	template.Must(bookmarkTemplate.Parse(box.MustString("base.html")))
+
+	deleteTemplate := template.New("delete")
+	template.Must(deleteTemplate.Parse(box.MustString("delete.html")))
+	template.Must(deleteTemplate.Parse(box.MustString("base.html")))
+
+	usageTemplate := template.New("usage")
+	template.Must(usageTemplate.Parse(box.MustString("usage.html")))
+	template.Must(usageTemplate.Parse(box.MustString("base.html")))
+
+	historyTemplate := template.New("history")
+	template.Must(historyTemplate.Parse(box.MustString("history.html")))
+	template.Must(historyTemplate.Parse(box.MustString("base.html")))
+
 	listTemplate := template.New("list")
 	template.Must(listTemplate.Parse(box.MustString("list.html")))
 	template.Must(listTemplate.Parse(box.MustString("base.html")))
@@ -323,8 +526,42 @@ func NewServer(bind string, config Config) *Server {
 	server.templates.Add("index", indexTemplate)
 	server.templates.Add("help", helpTemplate)
 	server.templates.Add("list", listTemplate)
+	server.templates.Add("confirm", confirmTemplate)
+	server.templates.Add("didyoumean", didYouMeanTemplate)
+	server.templates.Add("bookmark", bookmarkTemplate)
+	server.templates.Add("delete", deleteTemplate)
+	server.templates.Add("usage", usageTemplate)
+	server.templates.Add("history", historyTemplate)
 
 	server.initRoutes()
 
-	return server
+	server.server = &http.Server{
+		Addr:         bind,
+		Handler:      server.Handler(),
+		ReadTimeout:  config.ReadTimeout,
+		WriteTimeout: config.WriteTimeout,
+		IdleTimeout:  config.IdleTimeout,
+	}
+
+	// TLS
+	if config.TLSCert != "" {
+		certs, err := NewCertReloader(config.TLSCert, config.TLSKey, DefaultCertReloadInterval)
+		if err != nil {
+			return nil, fmt.Errorf("error loading certificate: %s", err)
+		}
+		server.certs = certs
+		server.server.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+
+		if config.HTTPRedirect != "" {
+			server.redirect = &http.Server{
+				Addr:         config.HTTPRedirect,
+				Handler:      server.RedirectHandler(),
+				ReadTimeout:  config.ReadTimeout,
+				WriteTimeout: config.WriteTimeout,
+				IdleTimeout:  config.IdleTimeout,
+			}
+		}
+	}
+
+	return server, nil
 }
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
@@ -10,7 +12,6 @@ import (
 	"time"
 
 	"github.com/julienschmidt/httprouter"
-	"github.com/prologic/bitcask"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -27,14 +28,30 @@ func (e Explode) Desc() string {
 	`
 }
 
+func (e Explode) ReadOnly() bool {
+	return true
+}
+
 func (e Explode) Exec(w http.ResponseWriter, r *http.Request, args []string) error {
 	return errors.New("kaboom")
 }
 
+// newTestServer returns a server for config, failing the test if it can't
+// be created
+func newTestServer(t *testing.T, config Config) *Server {
+	t.Helper()
+
+	s, err := NewServer(":8000", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
 func TestRender(t *testing.T) {
 	assert := assert.New(t)
 
-	s := NewServer(":8000", Config{})
+	s := newTestServer(t, Config{})
 	w := httptest.NewRecorder()
 
 	s.render("index", w, nil)
@@ -46,7 +63,7 @@ func TestRender(t *testing.T) {
 func TestRenderError(t *testing.T) {
 	assert := assert.New(t)
 
-	s := NewServer(":8000", Config{})
+	s := newTestServer(t, Config{})
 	w := httptest.NewRecorder()
 
 	s.render("asdf", w, nil)
@@ -57,7 +74,7 @@ func TestRenderError(t *testing.T) {
 func TestIndex(t *testing.T) {
 	assert := assert.New(t)
 
-	s := NewServer(":8000", Config{})
+	s := newTestServer(t, Config{})
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest("GET", "/", nil)
 	p := httprouter.Params{}
@@ -67,23 +84,10 @@ func TestIndex(t *testing.T) {
 	assert.Contains(w.Body.String(), `name="q"`)
 }
 
-func TestOpenSearch(t *testing.T) {
-	assert := assert.New(t)
-
-	s := NewServer(":8000", Config{})
-	w := httptest.NewRecorder()
-	r, _ := http.NewRequest("GET", "/opensearch.xml", nil)
-	p := httprouter.Params{}
-
-	s.OpenSearchHandler()(w, r, p)
-	assert.Equal(w.Code, http.StatusOK)
-	assert.Contains(w.Body.String(), "<OpenSearchDescription")
-}
-
 func TestCommand(t *testing.T) {
 	assert := assert.New(t)
 
-	s := NewServer(":8000", Config{})
+	s := newTestServer(t, Config{})
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest("GET", "/?q=ping", nil)
 	p := httprouter.Params{}
@@ -104,7 +108,7 @@ func TestCommand(t *testing.T) {
 func TestCaseInsensitive(t *testing.T) {
 	assert := assert.New(t)
 
-	s := NewServer(":8000", Config{})
+	s := newTestServer(t, Config{})
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest("GET", "/?q=Ping", nil)
 	p := httprouter.Params{}
@@ -125,10 +129,10 @@ func TestCaseInsensitive(t *testing.T) {
 func TestInvalidCommand(t *testing.T) {
 	assert := assert.New(t)
 
-	db, _ = bitcask.Open("test.db")
+	db = NewMemoryStore()
 	defer db.Close()
 
-	s := NewServer(":8000", Config{URL: ""})
+	s := newTestServer(t, Config{URL: ""})
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest("GET", "/?q=asdf", nil)
 	p := httprouter.Params{}
@@ -143,10 +147,10 @@ func TestInvalidCommand(t *testing.T) {
 func TestInvalidCommandDefaultURL(t *testing.T) {
 	assert := assert.New(t)
 
-	db, _ = bitcask.Open("test.db")
+	db = NewMemoryStore()
 	defer db.Close()
 
-	s := NewServer(":8000", Config{URL: DefaultURL})
+	s := newTestServer(t, Config{URL: DefaultURL})
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest("GET", "/?q=asdf", nil)
 	p := httprouter.Params{}
@@ -166,12 +170,12 @@ func TestInvalidCommandDefaultURL(t *testing.T) {
 func TestCommandError(t *testing.T) {
 	assert := assert.New(t)
 
-	db, _ = bitcask.Open("test.db")
+	db = NewMemoryStore()
 	defer db.Close()
 
 	RegisterCommand("explode", Explode{})
 
-	s := NewServer(":8000", Config{})
+	s := newTestServer(t, Config{})
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest("GET", "/?q=explode", nil)
 	p := httprouter.Params{}
@@ -186,13 +190,13 @@ func TestCommandError(t *testing.T) {
 func TestCommandBookmark(t *testing.T) {
 	assert := assert.New(t)
 
-	db, _ = bitcask.Open("test.db")
+	db = NewMemoryStore()
 	defer db.Close()
 
 	err := EnsureDefaultBookmarks()
 	assert.Nil(err)
 
-	s := NewServer(":8000", Config{})
+	s := newTestServer(t, Config{})
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest("GET", "/?q=g%20foo%20bar", nil)
 	p := httprouter.Params{}
@@ -205,6 +209,163 @@ func TestCommandBookmark(t *testing.T) {
 
 	assert.Equal(
 		w.Header().Get("Location"),
-		"https://www.google.com/search?q=foo bar&btnK",
+		"https://www.google.com/search?q=foo+bar&btnK",
+	)
+}
+
+func TestInvalidCommandDefaultURLEscaping(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	s := newTestServer(t, Config{URL: DefaultURL})
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/?q=c%23%20%26%20go%2Fbuild", nil)
+	p := httprouter.Params{}
+
+	s.IndexHandler()(w, r, p)
+	assert.Equal(
+		"https://www.google.com/search?q=c%23+%26+go%2Fbuild&btnK",
+		w.Header().Get("Location"),
+	)
+}
+
+func TestShortLinks(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	assert.Nil(SaveBookmark(NewBookmark("gh", "https://github.com/{owner}/{repo:}")))
+	assert.Nil(SaveBookmark(NewBookmark("wiki", "https://wiki.example.com/?q={query}")))
+
+	s := newTestServer(t, Config{URL: DefaultURL})
+
+	testCases := []struct {
+		path     string
+		code     int
+		location string
+	}{
+		{"/gh/prologic/golinks", http.StatusFound, "https://github.com/prologic/golinks"},
+		{"/gh/prologic/", http.StatusFound, "https://github.com/prologic/"},
+		{"/GH/prologic", http.StatusFound, "https://github.com/prologic/"},
+		{"/wiki/foo%20bar/baz", http.StatusFound, "https://wiki.example.com/?q=foo+bar+baz"},
+		{"/wiki/a%2Fb", http.StatusFound, "https://wiki.example.com/?q=a%2Fb"},
+		{"/wiki", http.StatusFound, "https://wiki.example.com/?q="},
+		{"/list/foo", http.StatusFound, "/list"},
+		{"/help", http.StatusOK, ""},
+		{"/asdf/foo", http.StatusNotFound, ""},
+		{"/favicon.ico", http.StatusNotFound, ""},
+	}
+
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", tc.path, nil)
+		s.router.ServeHTTP(w, r)
+
+		assert.Equal(tc.code, w.Code, tc.path)
+		assert.Equal(tc.location, w.Header().Get("Location"), tc.path)
+	}
+
+	// Commands that make changes still require confirmation
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/remove/wiki", nil)
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), "Remove bookmark wiki")
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/wiki", nil)
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestReservedNames(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, name := range []string{"api", "List", "help", "opensearch", "ping", "add"} {
+		err := ValidateName(name)
+		assert.NotNil(err, name)
+	}
+	assert.Nil(ValidateName("wiki"))
+}
+
+func TestServerShutdown(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	s := newTestServer(t, Config{
+		ReadTimeout:  time.Second,
+		WriteTimeout: 2 * time.Second,
+		IdleTimeout:  3 * time.Second,
+	})
+	assert.Equal(time.Second, s.server.ReadTimeout)
+	assert.Equal(2*time.Second, s.server.WriteTimeout)
+	assert.Equal(3*time.Second, s.server.IdleTimeout)
+
+	var (
+		started = make(chan struct{})
+		release = make(chan struct{})
 	)
+	s.router.GET("/slow", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Serve(l)
+	}()
+
+	resps := make(chan *http.Response, 1)
+	go func() {
+		res, err := http.Get("http://" + l.Addr().String() + "/slow")
+		assert.Nil(err)
+		resps <- res
+	}()
+	<-started
+
+	// In-flight requests are drained before Shutdown returns
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.Shutdown(context.Background())
+	}()
+	assert.Equal(http.ErrServerClosed, <-errs)
+
+	close(release)
+	assert.Nil(<-shutdown)
+
+	res := <-resps
+	assert.Equal(http.StatusOK, res.StatusCode)
+	res.Body.Close()
+
+	// New connections are refused
+	_, err = http.Get("http://" + l.Addr().String() + "/")
+	assert.Error(err)
+
+	// Shutdown gives up at the deadline
+	s = newTestServer(t, Config{})
+	started, release = make(chan struct{}), make(chan struct{})
+	defer close(release)
+	s.router.GET("/slow", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		close(started)
+		<-release
+	})
+
+	l, err = net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	go s.Serve(l)
+	go http.Get("http://" + l.Addr().String() + "/slow")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(context.DeadlineExceeded, s.Shutdown(ctx))
 }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrKeyNotFound is returned by a Store when a key does not exist
+	ErrKeyNotFound = errors.New("error: key not found")
+
+	// ErrStoreClosed is returned by a Store that has already been closed
+	ErrStoreClosed = errors.New("error: store closed")
+)
+
+// Store is a key/value storage backend for bookmarks and other data
+type Store interface {
+	// Get returns the value of key or ErrKeyNotFound
+	Get(key []byte) ([]byte, error)
+
+	// Has reports whether key exists
+	Has(key []byte) bool
+
+	// Put sets the value of key
+	Put(key, value []byte) error
+
+	// Delete removes key, it is not an error if key does not exist
+	Delete(key []byte) error
+
+	// Scan calls f for every key with the given prefix in lexical order.
+	// It is safe to modify the store from within f.
+	Scan(prefix []byte, f func(key []byte) error) error
+
+	// Len returns the total number of keys
+	Len() int
+
+	// Close closes the store
+	Close() error
+}
+
+// StoreFactory opens a Store at the given path
+type StoreFactory func(path string) (Store, error)
+
+var stores map[string]StoreFactory
+
+func init() {
+	stores = make(map[string]StoreFactory)
+	RegisterStore("bitcask", OpenBitcaskStore)
+	RegisterStore("bolt", OpenBoltStore)
+	RegisterStore("memory", func(path string) (Store, error) {
+		return NewMemoryStore(), nil
+	})
+}
+
+// RegisterStore ...
+func RegisterStore(name string, factory StoreFactory) {
+	stores[name] = factory
+}
+
+// OpenStore opens a Store of the named type at the given path, recording
+// metrics for its operations
+func OpenStore(name, path string) (Store, error) {
+	factory, ok := stores[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown store: %s", name)
+	}
+	store, err := factory(path)
+	if err != nil {
+		return nil, err
+	}
+	return InstrumentStore(name, store), nil
+}
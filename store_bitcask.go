@@ -0,0 +1,74 @@
+package main
+
+import (
+	"github.com/prologic/bitcask"
+)
+
+// BitcaskStore ...
+type BitcaskStore struct {
+	db *bitcask.Bitcask
+}
+
+// OpenBitcaskStore ...
+func OpenBitcaskStore(path string) (Store, error) {
+	db, err := bitcask.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &BitcaskStore{db: db}, nil
+}
+
+// Get ...
+func (s *BitcaskStore) Get(key []byte) ([]byte, error) {
+	val, err := s.db.Get(key)
+	if err == bitcask.ErrKeyNotFound {
+		return nil, ErrKeyNotFound
+	}
+	return val, err
+}
+
+// Has ...
+func (s *BitcaskStore) Has(key []byte) bool {
+	return s.db.Has(key)
+}
+
+// Put ...
+func (s *BitcaskStore) Put(key, value []byte) error {
+	return s.db.Put(key, value)
+}
+
+// Delete ...
+func (s *BitcaskStore) Delete(key []byte) error {
+	return s.db.Delete(key)
+}
+
+// Scan ...
+func (s *BitcaskStore) Scan(prefix []byte, f func(key []byte) error) error {
+	// bitcask walks its index without holding a lock so collect the keys
+	// first in case f modifies the store.
+	var keys [][]byte
+	err := s.db.Scan(prefix, func(key []byte) error {
+		keys = append(keys, append([]byte{}, key...))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := f(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len ...
+func (s *BitcaskStore) Len() int {
+	return s.db.Len()
+}
+
+// Close ...
+func (s *BitcaskStore) Close() error {
+	return s.db.Close()
+}
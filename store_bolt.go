@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("golinks")
+
+// BoltStore ...
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore ...
+func OpenBoltStore(path string) (Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// Get ...
+func (s *BoltStore) Get(key []byte) (val []byte, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltBucket).Get(key)
+		if v == nil {
+			return ErrKeyNotFound
+		}
+		val = append([]byte{}, v...)
+		return nil
+	})
+	return
+}
+
+// Has ...
+func (s *BoltStore) Has(key []byte) bool {
+	_, err := s.Get(key)
+	return err == nil
+}
+
+// Put ...
+func (s *BoltStore) Put(key, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put(key, value)
+	})
+}
+
+// Delete ...
+func (s *BoltStore) Delete(key []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete(key)
+	})
+}
+
+// Scan ...
+func (s *BoltStore) Scan(prefix []byte, f func(key []byte) error) error {
+	var keys [][]byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := f(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len ...
+func (s *BoltStore) Len() (n int) {
+	s.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(boltBucket).Stats().KeyN
+		return nil
+	})
+	return
+}
+
+// Close ...
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
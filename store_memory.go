@@ -0,0 +1,112 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryStore is a non-persistent Store useful for testing
+type MemoryStore struct {
+	sync.RWMutex
+
+	data   map[string][]byte
+	closed bool
+}
+
+// NewMemoryStore ...
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: make(map[string][]byte)}
+}
+
+// Get ...
+func (s *MemoryStore) Get(key []byte) ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.closed {
+		return nil, ErrStoreClosed
+	}
+
+	val, ok := s.data[string(key)]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return append([]byte{}, val...), nil
+}
+
+// Has ...
+func (s *MemoryStore) Has(key []byte) bool {
+	s.RLock()
+	defer s.RUnlock()
+
+	_, ok := s.data[string(key)]
+	return ok && !s.closed
+}
+
+// Put ...
+func (s *MemoryStore) Put(key, value []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	s.data[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+// Delete ...
+func (s *MemoryStore) Delete(key []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	delete(s.data, string(key))
+	return nil
+}
+
+// Scan ...
+func (s *MemoryStore) Scan(prefix []byte, f func(key []byte) error) error {
+	s.RLock()
+	if s.closed {
+		s.RUnlock()
+		return ErrStoreClosed
+	}
+	var keys []string
+	for key := range s.data {
+		if strings.HasPrefix(key, string(prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	s.RUnlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := f([]byte(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len ...
+func (s *MemoryStore) Len() int {
+	s.RLock()
+	defer s.RUnlock()
+
+	return len(s.data)
+}
+
+// Close ...
+func (s *MemoryStore) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.closed = true
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testStore(t *testing.T, store Store) {
+	assert := assert.New(t)
+
+	_, err := store.Get([]byte("foo"))
+	assert.Equal(ErrKeyNotFound, err)
+	assert.False(store.Has([]byte("foo")))
+
+	assert.Nil(store.Put([]byte("foo"), []byte("bar")))
+	assert.Nil(store.Put([]byte("bookmark_b"), []byte("2")))
+	assert.Nil(store.Put([]byte("bookmark_a"), []byte("1")))
+	assert.Equal(3, store.Len())
+	assert.True(store.Has([]byte("foo")))
+
+	val, err := store.Get([]byte("foo"))
+	assert.Nil(err)
+	assert.Equal([]byte("bar"), val)
+
+	var keys []string
+	err = store.Scan([]byte("bookmark_"), func(key []byte) error {
+		keys = append(keys, string(key))
+		return store.Delete(key)
+	})
+	assert.Nil(err)
+	assert.Equal([]string{"bookmark_a", "bookmark_b"}, keys)
+	assert.Equal(1, store.Len())
+
+	assert.Nil(store.Delete([]byte("foo")))
+	assert.Nil(store.Delete([]byte("foo")))
+	assert.Equal(0, store.Len())
+
+	assert.Nil(store.Close())
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	testStore(t, store)
+
+	assert.Equal(t, ErrStoreClosed, store.Put([]byte("foo"), []byte("bar")))
+}
+
+func TestBitcaskStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinks")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := OpenStore("bitcask", filepath.Join(dir, "test.db"))
+	assert.Nil(t, err)
+	testStore(t, store)
+}
+
+func TestBoltStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinks")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := OpenStore("bolt", filepath.Join(dir, "test.db"))
+	assert.Nil(t, err)
+	testStore(t, store)
+}
+
+func TestUnknownStore(t *testing.T) {
+	_, err := OpenStore("asdf", "")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,644 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// MaxSuggestions is the maximum number of search suggestions returned
+	MaxSuggestions = 10
+
+	// MaxSuggestResponseSize is the maximum size of a response read from a
+	// suggestions service
+	MaxSuggestResponseSize = 1 << 20
+)
+
+// ErrSuggestRateLimited is returned instead of upstream suggestions for
+// clients that exceed their rate limit
+var ErrSuggestRateLimited = errors.New("too many suggestion requests")
+
+// Suggestions are search suggestions for a query, encoded in the OpenSearch
+// suggestions format: [query, [completions], [descriptions], [urls]]
+type Suggestions struct {
+	Query        string
+	Completions  []string
+	Descriptions []string
+	URLs         []string
+}
+
+// Len ...
+func (s Suggestions) Len() int {
+	return len(s.Completions)
+}
+
+// Add adds a suggestion unless there is already one for the same
+// completion or the maximum number of suggestions is reached
+func (s *Suggestions) Add(completion, desc, url string) bool {
+	if s.Len() >= MaxSuggestions {
+		return false
+	}
+	for _, c := range s.Completions {
+		if strings.EqualFold(c, completion) {
+			return false
+		}
+	}
+	s.Completions = append(s.Completions, completion)
+	s.Descriptions = append(s.Descriptions, desc)
+	s.URLs = append(s.URLs, url)
+	return true
+}
+
+// Merge adds the suggestions of other after the existing ones
+func (s *Suggestions) Merge(other Suggestions) {
+	for i, completion := range other.Completions {
+		s.Add(completion, other.Descriptions[i], other.URLs[i])
+	}
+}
+
+// MarshalJSON encodes the suggestions in the OpenSearch suggestions format
+func (s Suggestions) MarshalJSON() ([]byte, error) {
+	nonNil := func(xs []string) []string {
+		if xs == nil {
+			return []string{}
+		}
+		return xs
+	}
+	return json.Marshal([]interface{}{
+		s.Query,
+		nonNil(s.Completions),
+		nonNil(s.Descriptions),
+		nonNil(s.URLs),
+	})
+}
+
+// UnmarshalJSON decodes suggestions in the OpenSearch suggestions format,
+// where the descriptions and urls are optional
+func (s *Suggestions) UnmarshalJSON(data []byte) error {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if len(fields) < 2 {
+		return fmt.Errorf("invalid suggestions: expected at least 2 fields got %d", len(fields))
+	}
+
+	var decoded Suggestions
+	if err := json.Unmarshal(fields[0], &decoded.Query); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(fields[1], &decoded.Completions); err != nil {
+		return err
+	}
+	if len(fields) > 2 {
+		// Some services put other data here, ignore it if so
+		json.Unmarshal(fields[2], &decoded.Descriptions)
+	}
+	if len(fields) > 3 {
+		json.Unmarshal(fields[3], &decoded.URLs)
+	}
+
+	n := len(decoded.Completions)
+	for len(decoded.Descriptions) < n {
+		decoded.Descriptions = append(decoded.Descriptions, "")
+	}
+	for len(decoded.URLs) < n {
+		decoded.URLs = append(decoded.URLs, "")
+	}
+	decoded.Descriptions = decoded.Descriptions[:n]
+	decoded.URLs = decoded.URLs[:n]
+
+	*s = decoded
+	return nil
+}
+
+// commandSummary returns the first line of a command's description
+func commandSummary(command Command) string {
+	desc := strings.TrimSpace(command.Desc())
+	if i := strings.IndexByte(desc, '\n'); i >= 0 {
+		desc = desc[:i]
+	}
+	return strings.TrimSpace(desc)
+}
+
+// LocalSuggestions returns suggestions for q from the bookmarks, commands
+// and aliases. A name being typed is completed to the names starting with
+// it, exact match first. Once arguments follow the name of a bookmark the
+// suggestions are the URL it expands to followed by its completions, and
+// for a command that implements Completer its completions. Suggestions link
+// to the bookmark itself where possible and otherwise to the server at base.
+func (s *Server) LocalSuggestions(q, base string) (Suggestions, error) {
+	suggestions := Suggestions{Query: q}
+
+	name := q
+	i := strings.IndexByte(q, ' ')
+	if i >= 0 {
+		name = q[:i]
+	}
+	if name == "" {
+		return suggestions, nil
+	}
+
+	if i >= 0 {
+		return suggestions, s.completeArgs(&suggestions, name, strings.Split(q[i+1:], " "), base)
+	}
+	name = strings.ToLower(name)
+
+	type candidate struct {
+		name, desc, url string
+	}
+	var candidates []candidate
+
+	bookmarks, err := ListBookmarksByPrefix(name)
+	if err != nil {
+		return suggestions, err
+	}
+	for _, bookmark := range bookmarks {
+		c := candidate{bookmark.Name(), bookmark.Desc(), base + "/" + bookmark.Name()}
+		if c.desc == "" {
+			c.desc = bookmark.URL()
+		}
+		if t, err := ParseURLTemplate(bookmark.URL()); err == nil && len(t.Placeholders()) == 0 {
+			if expanded, err := t.Expand(""); err == nil {
+				c.url = expanded
+			}
+		}
+		candidates = append(candidates, c)
+	}
+
+	for _, command := range commands {
+		if strings.HasPrefix(command.Name(), name) {
+			candidates = append(candidates, candidate{
+				command.Name(), commandSummary(command), base + "/" + command.Name(),
+			})
+		}
+	}
+
+	aliases, err := ListAliasesByPrefix(name)
+	if err != nil {
+		return suggestions, err
+	}
+	for _, alias := range aliases {
+		candidates = append(candidates, candidate{
+			alias.Name, "alias for " + alias.Target, base + "/" + alias.Name,
+		})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if (candidates[i].name == name) != (candidates[j].name == name) {
+			return candidates[i].name == name
+		}
+		return candidates[i].name < candidates[j].name
+	})
+
+	for _, c := range candidates {
+		suggestions.Add(c.name, c.desc, c.url)
+	}
+
+	return suggestions, nil
+}
+
+// completeArgs adds suggestions for the arguments typed after name
+func (s *Server) completeArgs(suggestions *Suggestions, name string, args []string, base string) error {
+	target, err := ResolveAlias(name)
+	if err != nil {
+		return nil
+	}
+
+	// Replaces the last argument typed with completion
+	complete := func(completion string) string {
+		return strings.Join(append([]string{name}, append(args[:len(args)-1:len(args)-1], completion)...), " ")
+	}
+
+	if command := LookupCommand(target); command != nil {
+		completer, ok := command.(Completer)
+		if !ok {
+			return nil
+		}
+		for _, completion := range completer.Complete(args) {
+			q := complete(completion)
+			suggestions.Add(q, commandSummary(command), base+"/?q="+url.QueryEscape(q))
+		}
+		return nil
+	}
+
+	bookmark, ok := LookupBookmark(target)
+	if !ok {
+		return nil
+	}
+	t, err := ParseURLTemplate(bookmark.URL())
+	if err != nil {
+		return err
+	}
+
+	if expanded, err := t.Expand(strings.TrimSpace(strings.Join(args, " "))); err == nil {
+		suggestions.Add(suggestions.Query, expanded, expanded)
+	}
+
+	completer := BookmarkCompleter{Bookmark: bookmark, Dir: s.config.CompletionsDir}
+	for _, completion := range completer.Complete(args) {
+		if expanded, err := t.Expand(completion); err == nil {
+			suggestions.Add(name+" "+completion, expanded, expanded)
+		}
+	}
+
+	return nil
+}
+
+// Formats of suggestion responses, any other format is a JSONPath
+// expression selecting the suggestions
+const (
+	// SuggestOpenSearch is the OpenSearch suggestions format
+	SuggestOpenSearch = "opensearch"
+	// SuggestList is a JSON array of suggestions or plain text with one
+	// suggestion per line
+	SuggestList = "list"
+)
+
+// nonPublicNetworks are the private, shared (carrier-grade NAT) and
+// "this network" ranges not covered by the net.IP predicates PublicIP uses
+var nonPublicNetworks, _ = ParseNetworks([]string{
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7",
+})
+
+// PublicIP reports whether ip is a public address, that is not a loopback,
+// private, link-local or unspecified one
+func PublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() &&
+		!ip.IsUnspecified() &&
+		!containsIP(nonPublicNetworks, ip)
+}
+
+// dialPublic is the net.Dialer Control function of suggestClient, it
+// refuses connections to addresses that aren't public. Checking the address
+// dialled rather than the host of the URL also covers redirects and names
+// that resolve to internal addresses.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !PublicIP(ip) {
+		return fmt.Errorf("refusing to connect to non-public address %s", host)
+	}
+	return nil
+}
+
+// parseSuggestURL returns the URL the suggestions URL template rawurl
+// expands to
+func parseSuggestURL(rawurl string) (*url.URL, error) {
+	if err := ValidateURL(rawurl); err != nil {
+		return nil, err
+	}
+	t, err := ParseURLTemplate(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	expanded, err := t.Expand(strings.Repeat("x ", t.maxPos+1))
+	if err != nil {
+		return nil, err
+	}
+	return url.Parse(expanded)
+}
+
+// ValidateSuggestURL checks that rawurl, if given, is a valid http or https
+// URL template to fetch suggestions from and not on a local address
+func ValidateSuggestURL(rawurl string) error {
+	if rawurl == "" {
+		return nil
+	}
+	u, err := parseSuggestURL(rawurl)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return invalidf("invalid suggest url %q: must be http or https", rawurl)
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return invalidf("invalid suggest url %q: must not be a local address", rawurl)
+	}
+	if ip := net.ParseIP(host); ip != nil && !PublicIP(ip) {
+		return invalidf("invalid suggest url %q: must not be a local or private address", rawurl)
+	}
+	return nil
+}
+
+// hostAllowed reports whether host is one of hosts or a subdomain of one
+func hostAllowed(hosts []string, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, allowed := range hosts {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == "" {
+			continue
+		}
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizeSuggestURL checks that the request may set the suggestions
+// service of a bookmark to rawurl. Admins may use any service, everyone
+// else only those on the configured suggest hosts as the server fetches
+// them on the user's behalf.
+func (s *Server) AuthorizeSuggestURL(r *http.Request, rawurl string) error {
+	if rawurl == "" {
+		return nil
+	}
+	if user, _ := RequestUser(r); user != nil && user.Admin {
+		return nil
+	}
+
+	u, err := parseSuggestURL(rawurl)
+	if err != nil {
+		return err
+	}
+	if hostAllowed(s.config.SuggestHosts, u.Hostname()) {
+		return nil
+	}
+	return invalidf("suggest url %q: only admins may fetch suggestions from %s", rawurl, u.Hostname())
+}
+
+// ValidateSuggestFormat checks that format is one of the suggestion
+// formats or a valid JSONPath expression
+func ValidateSuggestFormat(format string) error {
+	switch format {
+	case "", SuggestOpenSearch, SuggestList:
+		return nil
+	}
+	if !strings.HasPrefix(format, "$") {
+		return invalidf(
+			"invalid suggest format %q: must be %s, %s or a JSONPath expression like $[1][*]",
+			format, SuggestOpenSearch, SuggestList,
+		)
+	}
+	_, err := ParseJSONPath(format)
+	return err
+}
+
+// ParseSuggestions parses suggestions for q from a response body in the
+// given format, SuggestOpenSearch if empty
+func ParseSuggestions(format, q string, body []byte) (Suggestions, error) {
+	var suggestions Suggestions
+
+	switch format {
+	case "", SuggestOpenSearch:
+		if err := json.Unmarshal(body, &suggestions); err != nil {
+			return suggestions, err
+		}
+	case SuggestList:
+		var completions []string
+		if err := json.Unmarshal(body, &completions); err != nil {
+			completions = nil
+			for _, line := range strings.Split(string(body), "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					completions = append(completions, line)
+				}
+			}
+		}
+		for _, completion := range completions {
+			suggestions.Completions = append(suggestions.Completions, completion)
+			suggestions.Descriptions = append(suggestions.Descriptions, "")
+			suggestions.URLs = append(suggestions.URLs, "")
+		}
+	default:
+		path, err := ParseJSONPath(format)
+		if err != nil {
+			return suggestions, err
+		}
+		completions, err := path.Strings(body)
+		if err != nil {
+			return suggestions, err
+		}
+		for _, completion := range completions {
+			suggestions.Completions = append(suggestions.Completions, completion)
+			suggestions.Descriptions = append(suggestions.Descriptions, "")
+			suggestions.URLs = append(suggestions.URLs, "")
+		}
+	}
+
+	suggestions.Query = q
+	return suggestions, nil
+}
+
+// SuggestProvider is a service to fetch search suggestions from
+type SuggestProvider struct {
+	// URL is a URL template (see URLTemplate) for the query
+	URL string
+	// Format is the format of the responses, see ParseSuggestions
+	Format string
+	// Public is set for services of bookmarks, which may only be fetched
+	// from public addresses
+	Public bool
+}
+
+// SuggestProviderFor returns the service to fetch suggestions for q from and
+// the query to send it: the bookmark's own service for the arguments
+// following its name if it has one, otherwise the upstream service for all
+// of q. bookmark is the bookmark whose service is used, if any.
+func (s *Server) SuggestProviderFor(q string) (provider SuggestProvider, query string, bookmark *Bookmark) {
+	if i := strings.IndexByte(q, ' '); i > 0 {
+		if target, err := ResolveAlias(q[:i]); err == nil {
+			if b, ok := LookupBookmark(target); ok && b.SuggestURL() != "" {
+				provider := SuggestProvider{URL: b.SuggestURL(), Format: b.SuggestFormat(), Public: true}
+				return provider, q[i+1:], &b
+			}
+		}
+	}
+	return SuggestProvider{URL: s.config.SuggestURL}, q, nil
+}
+
+// UpstreamSuggestions fetches suggestions for q from provider, giving up
+// after the configured suggest timeout. Services of bookmarks are fetched
+// with suggestClient so they can't reach internal addresses.
+func (s *Server) UpstreamSuggestions(ctx context.Context, provider SuggestProvider, q string) (suggestions Suggestions, err error) {
+	if s.config.SuggestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.config.SuggestTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	defer func() {
+		suggestUpstreamDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			suggestUpstreamErrorsTotal.Inc()
+		}
+	}()
+
+	t, err := ParseURLTemplate(provider.URL)
+	if err != nil {
+		return suggestions, err
+	}
+	u, err := t.Expand(q)
+	if err != nil {
+		return suggestions, err
+	}
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return suggestions, err
+	}
+
+	c := client
+	if provider.Public {
+		c = suggestClient
+	}
+	resp, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		return suggestions, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return suggestions, fmt.Errorf("request failed: %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxSuggestResponseSize))
+	if err != nil {
+		return suggestions, err
+	}
+	return ParseSuggestions(provider.Format, q, body)
+}
+
+// CachedUpstreamSuggestions returns the suggestions for q from provider
+// from the cache if possible. Otherwise they are fetched, unless the client
+// exceeded its rate limit, sharing the result with any identical requests
+// made in the meantime.
+func (s *Server) CachedUpstreamSuggestions(r *http.Request, provider SuggestProvider, q string) (Suggestions, error) {
+	key := provider.URL + " " + provider.Format + " " + q
+
+	if s.suggestCache != nil {
+		if suggestions, ok := s.suggestCache.Get(key); ok {
+			suggestCacheRequestsTotal.WithLabelValues("hit").Inc()
+			return suggestions, nil
+		}
+		suggestCacheRequestsTotal.WithLabelValues("miss").Inc()
+	}
+
+	if s.suggestLimiter != nil && !s.suggestLimiter.Allow(RequestClient(r, s.trustedProxies)) {
+		suggestRateLimitedTotal.Inc()
+		return Suggestions{}, ErrSuggestRateLimited
+	}
+
+	suggestions, err, shared := s.suggestGroup.Do(key, func() (Suggestions, error) {
+		// Not tied to the request as the result may be shared with others
+		suggestions, err := s.UpstreamSuggestions(context.Background(), provider, q)
+		if err == nil && s.suggestCache != nil {
+			s.suggestCache.Add(key, suggestions)
+		}
+		return suggestions, err
+	})
+	if shared {
+		suggestCoalescedTotal.Inc()
+	}
+	return suggestions, err
+}
+
+// Prefixed returns the suggestions for the arguments of bookmark as
+// suggestions for the whole query, prefixed with name (the bookmark's name
+// or an alias of it as typed). Suggestions without a URL link to where the
+// bookmark leads with the suggestion as arguments.
+func (s Suggestions) Prefixed(name string, bookmark Bookmark) Suggestions {
+	prefixed := Suggestions{Query: name + " " + s.Query}
+
+	t, err := ParseURLTemplate(bookmark.URL())
+	for i, completion := range s.Completions {
+		u := s.URLs[i]
+		if u == "" && err == nil {
+			u, _ = t.Expand(completion)
+		}
+		prefixed.Completions = append(prefixed.Completions, name+" "+completion)
+		prefixed.Descriptions = append(prefixed.Descriptions, s.Descriptions[i])
+		prefixed.URLs = append(prefixed.URLs, u)
+	}
+
+	return prefixed
+}
+
+// Unprefixed returns the suggestions starting with prefix without it
+func (s Suggestions) Unprefixed(prefix string) Suggestions {
+	unprefixed := Suggestions{Query: strings.TrimPrefix(s.Query, prefix)}
+
+	for i, completion := range s.Completions {
+		if !strings.HasPrefix(completion, prefix) || completion == prefix {
+			continue
+		}
+		unprefixed.Completions = append(unprefixed.Completions, strings.TrimPrefix(completion, prefix))
+		unprefixed.Descriptions = append(unprefixed.Descriptions, s.Descriptions[i])
+		unprefixed.URLs = append(unprefixed.URLs, s.URLs[i])
+	}
+
+	return unprefixed
+}
+
+// SuggestionsHandler returns suggestions for ?q= from the bookmarks and
+// commands followed by those of the bookmark's own suggestions service, for
+// the arguments of a bookmark that has one, or else the upstream service.
+// Only the local suggestions are returned if the service fails or is too
+// slow or the client exceeded its rate limit.
+func (s *Server) SuggestionsHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		s.counters.Inc("n_suggest")
+
+		// Query ?q=
+		q := r.URL.Query().Get("q")
+
+		// Suggestions for the search engine of a single bookmark (see
+		// BookmarkOpenSearchHandler) are for its arguments only
+		var prefix string
+		if name := r.URL.Query().Get("bookmark"); name != "" {
+			prefix = name + " "
+			q = prefix + q
+		}
+
+		suggestions, err := s.LocalSuggestions(q, s.Scheme(r)+"://"+s.config.FQDN)
+		if err != nil {
+			log.Printf("error finding suggestions for %q: %s", q, err)
+		}
+
+		provider, query, bookmark := s.SuggestProviderFor(q)
+		if strings.TrimSpace(query) != "" && provider.URL != "" && suggestions.Len() < MaxSuggestions {
+			upstream, err := s.CachedUpstreamSuggestions(r, provider, query)
+			switch err {
+			case nil:
+				if bookmark != nil {
+					upstream = upstream.Prefixed(q[:len(q)-len(query)-1], *bookmark)
+				}
+				suggestions.Merge(upstream)
+			case ErrSuggestRateLimited:
+				// Only the local suggestions then
+			default:
+				log.Printf("error fetching suggestions for %q from %s: %s", query, provider.URL, err)
+			}
+		}
+
+		if prefix != "" {
+			suggestions = suggestions.Unprefixed(prefix)
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(suggestions); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getSuggestions(t *testing.T, s *Server, q string) Suggestions {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/suggest?q="+q, nil)
+	s.router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+	}
+
+	var suggestions Suggestions
+	if err := json.NewDecoder(w.Body).Decode(&suggestions); err != nil {
+		t.Fatal(err)
+	}
+	return suggestions
+}
+
+func TestSuggestionsJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	data, err := json.Marshal(Suggestions{Query: "foo"})
+	assert.Nil(err)
+	assert.Equal(`["foo",[],[],[]]`, string(data))
+
+	var suggestions Suggestions
+	assert.Nil(json.Unmarshal([]byte(`["fo",["foo","fob"]]`), &suggestions))
+	assert.Equal("fo", suggestions.Query)
+	assert.Equal([]string{"foo", "fob"}, suggestions.Completions)
+	assert.Equal([]string{"", ""}, suggestions.Descriptions)
+	assert.Equal([]string{"", ""}, suggestions.URLs)
+
+	assert.Nil(json.Unmarshal(
+		[]byte(`["fo",["foo"],["Foo"],["https://foo.example.com/"]]`), &suggestions,
+	))
+	assert.Equal([]string{"Foo"}, suggestions.Descriptions)
+	assert.Equal([]string{"https://foo.example.com/"}, suggestions.URLs)
+
+	// Other data in place of the descriptions is ignored
+	assert.Nil(json.Unmarshal([]byte(`["fo",["foo"],{"x":1}]`), &suggestions))
+	assert.Equal([]string{""}, suggestions.Descriptions)
+
+	assert.Error(json.Unmarshal([]byte(`["fo"]`), &suggestions))
+	assert.Error(json.Unmarshal([]byte(`{}`), &suggestions))
+}
+
+func TestLocalSuggestions(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	gh := NewBookmark("gh", "https://github.com/search?q={query}")
+	gh.desc = "Search GitHub"
+	assert.Nil(SaveBookmark(gh))
+	assert.Nil(SaveBookmark(NewBookmark("ghi", "https://github.com/issues")))
+	assert.Nil(SaveBookmark(NewBookmark("wiki", "https://wiki.example.com/")))
+	assert.Nil(SaveAlias(Alias{Name: "ghx", Target: "gh"}))
+
+	s := newTestServer(t, Config{})
+
+	suggestions, err := s.LocalSuggestions("gh", "http://go")
+	assert.Nil(err)
+	assert.Equal("gh", suggestions.Query)
+	assert.Equal([]string{"gh", "ghi", "ghx"}, suggestions.Completions)
+	assert.Equal([]string{"Search GitHub", "https://github.com/issues", "alias for gh"}, suggestions.Descriptions)
+	assert.Equal([]string{"http://go/gh", "https://github.com/issues", "http://go/ghx"}, suggestions.URLs)
+
+	// Commands are suggested too
+	suggestions, err = s.LocalSuggestions("pi", "http://go")
+	assert.Nil(err)
+	assert.Equal([]string{"ping"}, suggestions.Completions)
+	assert.Equal([]string{"ping"}, suggestions.Descriptions)
+	assert.Equal([]string{"http://go/ping"}, suggestions.URLs)
+
+	// Arguments to a bookmark (or an alias for one) suggest where it goes
+	suggestions, err = s.LocalSuggestions("ghx golinks", "http://go")
+	assert.Nil(err)
+	assert.Equal([]string{"ghx golinks"}, suggestions.Completions)
+	assert.Equal([]string{"https://github.com/search?q=golinks"}, suggestions.URLs)
+
+	suggestions, err = s.LocalSuggestions("foo bar", "http://go")
+	assert.Nil(err)
+	assert.Equal(0, suggestions.Len())
+
+	suggestions, err = s.LocalSuggestions("", "http://go")
+	assert.Nil(err)
+	assert.Equal(0, suggestions.Len())
+}
+
+func TestSuggestionsHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	assert.Nil(SaveBookmark(NewBookmark("gh", "https://github.com/search?q={query}")))
+
+	var slow int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&slow) == 1 {
+			time.Sleep(200 * time.Millisecond)
+		}
+		q := r.URL.Query().Get("q")
+		json.NewEncoder(w).Encode([]interface{}{q, []string{q, q + " tutorial", "gh"}})
+	}))
+	defer upstream.Close()
+
+	s := newTestServer(t, Config{
+		FQDN:           "go.example.com",
+		SuggestURL:     upstream.URL + "/?q=%s",
+		SuggestTimeout: 100 * time.Millisecond,
+	})
+
+	// Local suggestions come first, duplicates from upstream are dropped
+	suggestions := getSuggestions(t, s, "g")
+	assert.Equal([]string{"gh", "g", "g tutorial"}, suggestions.Completions)
+	assert.Equal([]string{"https://github.com/search?q={query}", "", ""}, suggestions.Descriptions)
+	assert.Equal([]string{"http://go.example.com/gh", "", ""}, suggestions.URLs)
+
+	// Slow upstream
+	atomic.StoreInt32(&slow, 1)
+	suggestions = getSuggestions(t, s, "g")
+	assert.Equal([]string{"gh"}, suggestions.Completions)
+	atomic.StoreInt32(&slow, 0)
+
+	// Unreachable upstream
+	upstream.Close()
+	suggestions = getSuggestions(t, s, "g")
+	assert.Equal([]string{"gh"}, suggestions.Completions)
+
+	suggestions = getSuggestions(t, s, "")
+	assert.Equal(0, suggestions.Len())
+}
+
+func TestParseSuggestions(t *testing.T) {
+	assert := assert.New(t)
+
+	testCases := []struct {
+		format   string
+		body     string
+		expected []string
+	}{
+		{"", `["go",["golang","golinks"]]`, []string{"golang", "golinks"}},
+		{SuggestOpenSearch, `["go",["golang"],["The Go language"],["https://golang.org"]]`, []string{"golang"}},
+		{SuggestList, `["golang","golinks"]`, []string{"golang", "golinks"}},
+		{SuggestList, "golang\n\n  golinks  \n", []string{"golang", "golinks"}},
+		{"$.items[*].title", `{"items":[{"title":"golang"},{"title":"golinks"}]}`, []string{"golang", "golinks"}},
+		{"$.missing[*]", `{"items":[]}`, nil},
+	}
+
+	for _, testCase := range testCases {
+		suggestions, err := ParseSuggestions(testCase.format, "go", []byte(testCase.body))
+		assert.Nil(err, testCase.format)
+		assert.Equal("go", suggestions.Query)
+		assert.Equal(testCase.expected, suggestions.Completions, testCase.format)
+		assert.Equal(len(testCase.expected), len(suggestions.Descriptions))
+		assert.Equal(len(testCase.expected), len(suggestions.URLs))
+	}
+
+	suggestions, err := ParseSuggestions(SuggestOpenSearch, "go", []byte(`["go",["golang"],["The Go language"],["https://golang.org"]]`))
+	assert.Nil(err)
+	assert.Equal([]string{"The Go language"}, suggestions.Descriptions)
+	assert.Equal([]string{"https://golang.org"}, suggestions.URLs)
+
+	_, err = ParseSuggestions(SuggestOpenSearch, "go", []byte(`golang`))
+	assert.Error(err)
+	_, err = ParseSuggestions("$.items", "go", []byte(`golang`))
+	assert.Error(err)
+}
+
+func TestValidateSuggestFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, format := range []string{"", SuggestOpenSearch, SuggestList, "$[1][*]", "$.items[*].title"} {
+		assert.Nil(ValidateSuggestFormat(format), format)
+	}
+	for _, format := range []string{"xml", "$[", "items"} {
+		assert.Error(ValidateSuggestFormat(format), format)
+	}
+
+	assert.Nil(ValidateSuggestURL(""))
+	assert.Nil(ValidateSuggestURL("https://example.com/suggest?q={query}"))
+	assert.Nil(ValidateSuggestURL("http://93.184.216.34/suggest?q={query}"))
+	for _, rawurl := range []string{
+		"/suggest?q={query}",
+		"ftp://example.com/{query}",
+		"http://localhost:8000/?q={query}",
+		"http://127.0.0.1/?q={query}",
+		"http://10.1.2.3/?q={query}",
+		"http://192.168.0.1/?q={query}",
+		"http://169.254.169.254/latest/{query}",
+		"http://[::1]/?q={query}",
+		"http://[fe80::1]/?q={query}",
+		"http://0.0.0.0/?q={query}",
+	} {
+		assert.Error(ValidateSuggestURL(rawurl), rawurl)
+	}
+}
+
+func TestDialPublic(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(dialPublic("tcp", "93.184.216.34:443", nil))
+	assert.Nil(dialPublic("tcp6", "[2606:2800:220:1::]:443", nil))
+	for _, address := range []string{
+		"127.0.0.1:80", "10.0.0.1:80", "172.16.0.1:80", "192.168.1.1:80", "100.64.0.1:80",
+		"169.254.169.254:80", "0.0.0.0:80", "[::1]:80", "[fe80::1]:80", "[fd00::1]:80",
+		"[::ffff:127.0.0.1]:80",
+	} {
+		assert.Error(dialPublic("tcp", address, nil), address)
+	}
+}
+
+func TestBookmarkSuggestions(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	var paths []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		q := r.URL.Query().Get("q")
+		switch r.URL.Path {
+		case "/wp":
+			json.NewEncoder(w).Encode([]interface{}{
+				q, []string{q + " (film)", q + " (band)"}, []string{"", "Rock band"}, []string{"", "https://wp.example.com/band"},
+			})
+		case "/yt":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"results": []map[string]string{{"title": q + " live"}, {"title": q + " remix"}},
+			})
+		case "/list":
+			w.Write([]byte(q + " one\n" + q + " two\n"))
+		default:
+			json.NewEncoder(w).Encode([]interface{}{q, []string{"default " + q}})
+		}
+	}))
+	defer upstream.Close()
+
+	wp := NewBookmark("wp", "https://wp.example.com/?search={query}")
+	wp.suggestURL = upstream.URL + "/wp?q={query}"
+	assert.Nil(SaveBookmark(wp))
+	yt := NewBookmark("yt", "https://yt.example.com/results?q={query}")
+	yt.suggestURL = upstream.URL + "/yt?q={query}"
+	yt.suggestFormat = "$.results[*].title"
+	assert.Nil(SaveBookmark(yt))
+	ls := NewBookmark("ls", "https://ls.example.com/{+query}")
+	ls.suggestURL = upstream.URL + "/list?q={query}"
+	ls.suggestFormat = SuggestList
+	assert.Nil(SaveBookmark(ls))
+	assert.Nil(SaveBookmark(NewBookmark("gh", "https://github.com/search?q={query}")))
+	assert.Nil(SaveAlias(Alias{Name: "wiki", Target: "wp"}))
+
+	s := newTestServer(t, Config{
+		FQDN:       "go.example.com",
+		SuggestURL: upstream.URL + "/default?q=%s",
+	})
+
+	// The services of bookmarks may not be on local addresses
+	suggestions := getSuggestions(t, s, "wp+foo")
+	assert.Equal([]string{"wp foo"}, suggestions.Completions)
+	assert.Empty(paths)
+
+	// but upstream is for this test
+	defer func(c *http.Client) { suggestClient = c }(suggestClient)
+	suggestClient = client
+
+	// Results of the bookmark's provider are prefixed with its name and
+	// link to the bookmark's URL unless the provider gives one
+	suggestions = getSuggestions(t, s, "wp+foo")
+	assert.Equal([]string{"wp foo", "wp foo (film)", "wp foo (band)"}, suggestions.Completions)
+	assert.Equal([]string{
+		"https://wp.example.com/?search=foo",
+		"https://wp.example.com/?search=foo+%28film%29",
+		"https://wp.example.com/band",
+	}, suggestions.URLs)
+	assert.Equal("Rock band", suggestions.Descriptions[2])
+
+	suggestions = getSuggestions(t, s, "yt+foo")
+	assert.Equal([]string{"yt foo", "yt foo live", "yt foo remix"}, suggestions.Completions)
+
+	suggestions = getSuggestions(t, s, "ls+a/b")
+	assert.Equal([]string{"ls a/b", "ls a/b one", "ls a/b two"}, suggestions.Completions)
+
+	// Aliases keep the name typed
+	suggestions = getSuggestions(t, s, "wiki+foo")
+	assert.Equal([]string{"wiki foo", "wiki foo (film)", "wiki foo (band)"}, suggestions.Completions)
+
+	// Bookmarks without a provider and other queries use the default one
+	// with the whole query
+	suggestions = getSuggestions(t, s, "gh+foo")
+	assert.Equal([]string{"gh foo", "default gh foo"}, suggestions.Completions)
+	suggestions = getSuggestions(t, s, "foo")
+	assert.Equal([]string{"default foo"}, suggestions.Completions)
+
+	// Nothing to ask the provider for yet
+	paths = nil
+	suggestions = getSuggestions(t, s, "wp+")
+	assert.Equal([]string{"wp "}, suggestions.Completions)
+	assert.Empty(paths)
+}
+
+func TestArgumentSuggestions(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	dir, err := ioutil.TempDir("", "golinks")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, "repos.txt"), []byte("prologic/golinks\nprologic/bitcask\n"), 0644))
+
+	gh := NewBookmark("gh", "https://github.com/{+query}")
+	gh.completions = []string{"golang/go"}
+	gh.completionsFile = "repos.txt"
+	assert.Nil(SaveBookmark(gh))
+	assert.Nil(SaveBookmark(NewBookmark("wiki", "https://wiki.example.com/")))
+
+	s := newTestServer(t, Config{FQDN: "go.example.com", CompletionsDir: dir})
+
+	suggestions := getSuggestions(t, s, "gh+prologic/")
+	assert.Equal([]string{"gh prologic/", "gh prologic/golinks", "gh prologic/bitcask"}, suggestions.Completions)
+	assert.Equal([]string{
+		"https://github.com/prologic/",
+		"https://github.com/prologic/golinks",
+		"https://github.com/prologic/bitcask",
+	}, suggestions.URLs)
+
+	suggestions = getSuggestions(t, s, "remove+w")
+	assert.Equal([]string{"remove wiki"}, suggestions.Completions)
+	assert.Equal([]string{"remove [name]"}, suggestions.Descriptions)
+	assert.Equal([]string{"http://go.example.com/?q=remove+wiki"}, suggestions.URLs)
+
+	suggestions = getSuggestions(t, s, "help+pi")
+	assert.Equal([]string{"help ping"}, suggestions.Completions)
+
+	// Commands without completions
+	suggestions = getSuggestions(t, s, "ping+x")
+	assert.Equal(0, suggestions.Len())
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"container/list"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DefaultSuggestCacheSize is the number of upstream suggestion responses
+	// kept in the cache
+	DefaultSuggestCacheSize = 1000
+	// DefaultSuggestCacheTTL is how long upstream suggestions are cached for
+	DefaultSuggestCacheTTL = 10 * time.Minute
+	// DefaultSuggestRate is how many upstream suggestion requests per second
+	// each client may cause
+	DefaultSuggestRate = 5.0
+	// DefaultSuggestBurst is how many upstream suggestion requests each
+	// client may cause at once
+	DefaultSuggestBurst = 10
+)
+
+type suggestCacheEntry struct {
+	key         string
+	suggestions Suggestions
+	expires     time.Time
+}
+
+// SuggestCache is a least recently used cache of suggestions whose entries
+// expire after a fixed time
+type SuggestCache struct {
+	sync.Mutex
+
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// NewSuggestCache returns a cache holding up to size entries for ttl each
+func NewSuggestCache(size int, ttl time.Duration) *SuggestCache {
+	return &SuggestCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Get returns the suggestions cached for key unless they expired
+func (c *SuggestCache) Get(key string) (Suggestions, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return Suggestions{}, false
+	}
+	entry := elem.Value.(*suggestCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return Suggestions{}, false
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.suggestions, true
+}
+
+// Add caches suggestions for key, evicting the least recently used entry if
+// the cache is full
+func (c *SuggestCache) Add(key string, suggestions Suggestions) {
+	c.Lock()
+	defer c.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*suggestCacheEntry)
+		entry.suggestions, entry.expires = suggestions, expires
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&suggestCacheEntry{key, suggestions, expires})
+	for c.order.Len() > c.size {
+		elem := c.order.Back()
+		c.order.Remove(elem)
+		delete(c.entries, elem.Value.(*suggestCacheEntry).key)
+	}
+}
+
+// Len returns the number of cached entries, including expired ones not yet
+// evicted
+func (c *SuggestCache) Len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.order.Len()
+}
+
+type suggestCall struct {
+	wg          sync.WaitGroup
+	suggestions Suggestions
+	err         error
+}
+
+// suggestGroup coalesces concurrent fetches of suggestions for the same key
+// into one, whose result is shared by all callers
+type suggestGroup struct {
+	sync.Mutex
+	calls map[string]*suggestCall
+}
+
+// Do calls fetch for key unless a call for key is in progress already, in
+// which case it waits for that and returns its result. shared reports
+// whether the result came from another caller's call.
+func (g *suggestGroup) Do(key string, fetch func() (Suggestions, error)) (suggestions Suggestions, err error, shared bool) {
+	g.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*suggestCall)
+	}
+	if call, ok := g.calls[key]; ok {
+		g.Unlock()
+		call.wg.Wait()
+		return call.suggestions, call.err, true
+	}
+	call := &suggestCall{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.Unlock()
+
+	call.suggestions, call.err = fetch()
+	call.wg.Done()
+
+	g.Lock()
+	delete(g.calls, key)
+	g.Unlock()
+
+	return call.suggestions, call.err, false
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// ClientLimiter limits the rate of requests of each client separately
+type ClientLimiter struct {
+	sync.Mutex
+
+	rate    rate.Limit
+	burst   int
+	clients map[string]*clientLimiter
+	swept   time.Time
+}
+
+// NewClientLimiter allows each client r requests per second with bursts of
+// up to burst requests
+func NewClientLimiter(r float64, burst int) *ClientLimiter {
+	return &ClientLimiter{
+		rate:    rate.Limit(r),
+		burst:   burst,
+		clients: make(map[string]*clientLimiter),
+		swept:   time.Now(),
+	}
+}
+
+// Allow reports whether client may make a request now
+func (l *ClientLimiter) Allow(client string) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	now := time.Now()
+
+	// Forget clients that have been idle long enough to have a full burst
+	// again, every so often
+	idle := time.Minute
+	if l.rate > 0 {
+		if full := time.Duration(float64(l.burst) / float64(l.rate) * float64(time.Second)); full > idle {
+			idle = full
+		}
+	}
+	if now.Sub(l.swept) > idle {
+		for key, c := range l.clients {
+			if now.Sub(c.lastSeen) > idle {
+				delete(l.clients, key)
+			}
+		}
+		l.swept = now
+	}
+
+	c, ok := l.clients[client]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(l.rate, l.burst)}
+		l.clients[client] = c
+	}
+	c.lastSeen = now
+	return c.limiter.AllowN(now, 1)
+}
+
+// RequestClient identifies the client making a request for rate limiting:
+// the authenticated user if any, otherwise the remote IP address. Requests
+// from trusted reverse proxies are from the last address in X-Forwarded-For
+// that isn't one of them, as otherwise all clients behind a proxy would be
+// limited together.
+func RequestClient(r *http.Request, trusted []*net.IPNet) string {
+	if user, _ := RequestUser(r); user != nil {
+		return "user:" + user.Name
+	}
+
+	ip := remoteIP(r)
+	if ip == nil {
+		return r.RemoteAddr
+	}
+
+	if containsIP(trusted, ip) {
+		hops := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				break
+			}
+			ip = hop
+			if !containsIP(trusted, hop) {
+				break
+			}
+		}
+	}
+
+	return ip.String()
+}
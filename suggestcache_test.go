@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuggestCache(t *testing.T) {
+	assert := assert.New(t)
+
+	cache := NewSuggestCache(2, time.Hour)
+	cache.Add("a", Suggestions{Query: "a"})
+	cache.Add("b", Suggestions{Query: "b"})
+
+	suggestions, ok := cache.Get("a")
+	assert.True(ok)
+	assert.Equal("a", suggestions.Query)
+
+	// b is the least recently used now
+	cache.Add("c", Suggestions{Query: "c"})
+	assert.Equal(2, cache.Len())
+	_, ok = cache.Get("b")
+	assert.False(ok)
+	_, ok = cache.Get("a")
+	assert.True(ok)
+	_, ok = cache.Get("c")
+	assert.True(ok)
+
+	cache.Add("c", Suggestions{Query: "c", Completions: []string{"cc"}})
+	suggestions, _ = cache.Get("c")
+	assert.Equal([]string{"cc"}, suggestions.Completions)
+	assert.Equal(2, cache.Len())
+
+	cache = NewSuggestCache(2, 10*time.Millisecond)
+	cache.Add("a", Suggestions{Query: "a"})
+	time.Sleep(20 * time.Millisecond)
+	_, ok = cache.Get("a")
+	assert.False(ok)
+	assert.Equal(0, cache.Len())
+}
+
+func TestSuggestGroup(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		group   suggestGroup
+		calls   int32
+		release = make(chan struct{})
+		wg      sync.WaitGroup
+		shares  int32
+	)
+
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			suggestions, err, shared := group.Do("q", func() (Suggestions, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return Suggestions{Query: "q"}, nil
+			})
+			assert.Nil(err)
+			assert.Equal("q", suggestions.Query)
+			if shared {
+				atomic.AddInt32(&shares, 1)
+			}
+		}()
+	}
+
+	// Wait for the others to join the first call
+	for {
+		group.Lock()
+		call := group.calls["q"]
+		group.Unlock()
+		if call != nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(atomic.LoadInt32(&calls)+atomic.LoadInt32(&shares), int32(5))
+	assert.True(atomic.LoadInt32(&calls) < 5)
+
+	// Later calls are not coalesced with finished ones
+	_, _, shared := group.Do("q", func() (Suggestions, error) {
+		return Suggestions{}, nil
+	})
+	assert.False(shared)
+}
+
+func TestClientLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	limiter := NewClientLimiter(1, 2)
+	assert.True(limiter.Allow("alice"))
+	assert.True(limiter.Allow("alice"))
+	assert.False(limiter.Allow("alice"))
+
+	// Clients are limited separately
+	assert.True(limiter.Allow("bob"))
+
+	r, _ := http.NewRequest("GET", "/suggest", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	assert.Equal("192.0.2.1", RequestClient(r, nil))
+}
+
+func TestRequestClient(t *testing.T) {
+	assert := assert.New(t)
+
+	trusted, err := ParseNetworks([]string{"127.0.0.0/8", " 10.0.0.0/8"})
+	assert.Nil(err)
+
+	testCases := []struct {
+		remoteAddr   string
+		forwardedFor []string
+		expected     string
+	}{
+		{"192.0.2.1:1234", nil, "192.0.2.1"},
+		// Untrusted clients can't pick their address
+		{"192.0.2.1:1234", []string{"198.51.100.1"}, "192.0.2.1"},
+		{"127.0.0.1:1234", nil, "127.0.0.1"},
+		{"127.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		// Addresses before the first untrusted one may be forged
+		{"127.0.0.1:1234", []string{"203.0.113.7, 198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"127.0.0.1:1234", []string{"203.0.113.7", "198.51.100.1"}, "198.51.100.1"},
+		{"127.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"127.0.0.1:1234", []string{"garbage"}, "127.0.0.1"},
+	}
+
+	for _, testCase := range testCases {
+		r, _ := http.NewRequest("GET", "/suggest", nil)
+		r.RemoteAddr = testCase.remoteAddr
+		r.Header["X-Forwarded-For"] = testCase.forwardedFor
+		assert.Equal(testCase.expected, RequestClient(r, trusted), testCase.forwardedFor)
+	}
+
+	_, err = ParseNetworks([]string{"10.0.0.0/33"})
+	assert.Error(err)
+}
+
+func TestCachedSuggestions(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	var requests int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		q := r.URL.Query().Get("q")
+		json.NewEncoder(w).Encode([]interface{}{q, []string{q + " tutorial"}})
+	}))
+	defer upstream.Close()
+
+	s := newTestServer(t, Config{
+		SuggestURL:       upstream.URL + "/?q=%s",
+		SuggestCacheSize: 10,
+		SuggestCacheTTL:  time.Hour,
+		SuggestRate:      1,
+		SuggestBurst:     2,
+	})
+
+	hits := testutil.ToFloat64(suggestCacheRequestsTotal.WithLabelValues("hit"))
+	misses := testutil.ToFloat64(suggestCacheRequestsTotal.WithLabelValues("miss"))
+	limited := testutil.ToFloat64(suggestRateLimitedTotal)
+
+	for i := 0; i < 3; i++ {
+		suggestions := getSuggestions(t, s, "golang")
+		assert.Equal([]string{"golang tutorial"}, suggestions.Completions)
+	}
+	assert.Equal(int32(1), atomic.LoadInt32(&requests))
+	assert.Equal(hits+2, testutil.ToFloat64(suggestCacheRequestsTotal.WithLabelValues("hit")))
+	assert.Equal(misses+1, testutil.ToFloat64(suggestCacheRequestsTotal.WithLabelValues("miss")))
+
+	// Cache misses count against the rate limit of the client
+	suggestions := getSuggestions(t, s, "gopher")
+	assert.Equal([]string{"gopher tutorial"}, suggestions.Completions)
+	suggestions = getSuggestions(t, s, "goroutine")
+	assert.Equal(0, suggestions.Len())
+	assert.Equal(int32(2), atomic.LoadInt32(&requests))
+	assert.Equal(limited+1, testutil.ToFloat64(suggestRateLimitedTotal))
+
+	// Cached suggestions are still served
+	suggestions = getSuggestions(t, s, "golang")
+	assert.Equal([]string{"golang tutorial"}, suggestions.Completions)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/metrics", nil)
+	MetricsHandler().ServeHTTP(w, r)
+	assert.Contains(w.Body.String(), `golinks_suggest_cache_requests_total{result="hit"}`)
+}
@@ -9,17 +9,6 @@ import (
 	"sync"
 )
 
-const OpenSearchTemplate string = `<?xml version="1.0" encoding="UTF-8"?>
-<OpenSearchDescription xmlns="http://a9.com/-/spec/opensearch/1.1/">
-  <ShortName>%s</ShortName>
-  <Description>Smart bookmarks</Description>
-  <Tags>search</Tags>
-  <Contact>admin@localhost</Contact>
-  <Url type="text/html" method="get" template="http://%s/?q={searchTerms}"/>
-  <Url type="application/x-suggestions+json" method="get" template="http://%s/suggest?q={searchTerms}"/>
-</OpenSearchDescription>
-`
-
 type TemplateMap map[string]*template.Template
 
 type Templates struct {
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultCertReloadInterval is how often the certificate files are checked
+// for changes
+const DefaultCertReloadInterval = time.Minute
+
+// CertReloader serves a TLS certificate loaded from files on disk and
+// reloads it when the files change, e.g. when rotated by cert-manager
+type CertReloader struct {
+	sync.RWMutex
+
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+}
+
+// NewCertReloader loads the certificate and key from the given files
+func NewCertReloader(certFile, keyFile string, interval time.Duration) (*CertReloader, error) {
+	c := &CertReloader{certFile: certFile, keyFile: keyFile, interval: interval}
+	if _, err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *CertReloader) stat() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+	for i, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// Reload loads the certificate again if either file changed since it was
+// last loaded, reporting whether it did. The current certificate is kept if
+// the new one cannot be loaded, for example when only one of the files has
+// been replaced so far, and loading is retried on the next call.
+func (c *CertReloader) Reload() (bool, error) {
+	modTimes, err := c.stat()
+	if err != nil {
+		return false, err
+	}
+
+	c.RLock()
+	unchanged := c.cert != nil && modTimes == c.modTimes
+	c.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	c.Lock()
+	c.cert, c.modTimes = &cert, modTimes
+	c.Unlock()
+
+	return true, nil
+}
+
+// GetCertificate returns the current certificate, for use in tls.Config
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.RLock()
+	defer c.RUnlock()
+	return c.cert, nil
+}
+
+// Run checks the certificate files for changes every interval until stop
+// is closed
+func (c *CertReloader) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := c.Reload()
+			if err != nil {
+				log.Printf("error reloading certificate %s: %s", c.certFile, err)
+			} else if reloaded {
+				log.Printf("reloaded certificate %s", c.certFile)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// RedirectHandler redirects plain HTTP requests to the same path over HTTPS
+// on the public address of the server
+func (s *Server) RedirectHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.counters.Inc("n_https_redirect")
+
+		code := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			code = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+s.config.FQDN+r.URL.RequestURI(), code)
+	})
+}
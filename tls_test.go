@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCert writes a self-signed certificate for localhost with the given
+// serial number and its key to cert.pem and key.pem in dir
+func writeCert(t *testing.T, dir string, serial int64) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(certFile, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make sure the change is noticed even on filesystems with coarse mtimes
+	later := time.Now().Add(time.Duration(serial) * time.Minute)
+	os.Chtimes(certFile, later, later)
+	os.Chtimes(keyFile, later, later)
+
+	return certFile, keyFile
+}
+
+func certSerial(t *testing.T, c *CertReloader) int64 {
+	cert, err := c.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.SerialNumber.Int64()
+}
+
+func TestCertReloader(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "golinks")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	_, err = NewCertReloader(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), time.Minute)
+	assert.Error(err)
+
+	certFile, keyFile := writeCert(t, dir, 1)
+	certs, err := NewCertReloader(certFile, keyFile, time.Minute)
+	assert.Nil(err)
+	assert.Equal(int64(1), certSerial(t, certs))
+
+	reloaded, err := certs.Reload()
+	assert.Nil(err)
+	assert.False(reloaded)
+
+	writeCert(t, dir, 2)
+	reloaded, err = certs.Reload()
+	assert.Nil(err)
+	assert.True(reloaded)
+	assert.Equal(int64(2), certSerial(t, certs))
+
+	// A mismatched pair (e.g. halfway through a rotation) keeps the old
+	// certificate and is retried
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	assert.Nil(err)
+	writeCert(t, dir, 3)
+	assert.Nil(ioutil.WriteFile(keyFile, keyPEM, 0600))
+
+	_, err = certs.Reload()
+	assert.Error(err)
+	assert.Equal(int64(2), certSerial(t, certs))
+
+	writeCert(t, dir, 4)
+	reloaded, err = certs.Reload()
+	assert.Nil(err)
+	assert.True(reloaded)
+	assert.Equal(int64(4), certSerial(t, certs))
+}
+
+func TestServeTLS(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	dir, err := ioutil.TempDir("", "golinks")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeCert(t, dir, 1)
+	s := newTestServer(t, Config{
+		Title:   "Search",
+		FQDN:    "go.example.com",
+		TLSCert: certFile,
+		TLSKey:  keyFile,
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	go s.Serve(l)
+	defer s.Shutdown(context.Background())
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	res, err := client.Get("https://" + l.Addr().String() + "/opensearch.xml")
+	assert.Nil(err)
+	defer res.Body.Close()
+	assert.Equal(http.StatusOK, res.StatusCode)
+	assert.Equal(int64(1), res.TLS.PeerCertificates[0].SerialNumber.Int64())
+
+	body, err := ioutil.ReadAll(res.Body)
+	assert.Nil(err)
+	assert.Contains(string(body), `template="https://go.example.com/?q={searchTerms}"`)
+	assert.Contains(string(body), `template="https://go.example.com/suggest?q={searchTerms}"`)
+}
+
+func TestNewServerInvalidCert(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "golinks")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	s, err := NewServer(":8000", Config{
+		TLSCert: filepath.Join(dir, "missing.pem"),
+		TLSKey:  filepath.Join(dir, "missing.key"),
+	})
+	assert.Nil(s)
+	assert.Error(err)
+	assert.Contains(err.Error(), "error loading certificate")
+}
+
+func TestRedirectHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newTestServer(t, Config{FQDN: "go.example.com"})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://go.example.com/wiki?q=foo%20bar", nil)
+	s.RedirectHandler().ServeHTTP(w, r)
+	assert.Equal(http.StatusMovedPermanently, w.Code)
+	assert.Equal("https://go.example.com/wiki?q=foo%20bar", w.Header().Get("Location"))
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "http://go.example.com/api/v1/bookmarks", nil)
+	s.RedirectHandler().ServeHTTP(w, r)
+	assert.Equal(http.StatusPermanentRedirect, w.Code)
+	assert.Equal("https://go.example.com/api/v1/bookmarks", w.Header().Get("Location"))
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type partKind int
+
+const (
+	literalPart partKind = iota
+	positionalPart
+	restPart
+	queryPart
+)
+
+// urlContext is the part of a URL a placeholder appears in, which
+// determines how values substituted into it are escaped
+type urlContext int
+
+const (
+	pathContext urlContext = iota
+	queryContext
+	fragmentContext
+)
+
+var placeholderName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_-]*$`)
+
+type templatePart struct {
+	kind    partKind
+	literal string
+	pos     int
+	name    string
+	def     string
+	hasDef  bool
+	raw     bool
+	context urlContext
+}
+
+// escape escapes value for the part of the URL the placeholder is in
+func (p templatePart) escape(value string) string {
+	if p.raw {
+		return value
+	}
+
+	switch p.context {
+	case queryContext:
+		return url.QueryEscape(value)
+	default:
+		return url.PathEscape(value)
+	}
+}
+
+func (p templatePart) label() string {
+	if p.name != "" {
+		return p.name
+	}
+	return strconv.Itoa(p.pos)
+}
+
+// URLTemplate is a bookmark URL with placeholders for the arguments given
+// when the bookmark is used. The supported placeholders are:
+//
+//	{1}, {2}, ...  the 1st, 2nd, ... argument
+//	{name}         a named argument, named arguments are positional in the
+//	               order they first appear, so {owner}/{repo} is {1}/{2}
+//	{rest}         all arguments after the last positional one
+//	{query}, %s    all arguments as given
+//
+// Any placeholder may have a default used if the argument is missing, for
+// example {1:main}. Use {{ and }} for literal braces and %% for a literal %
+// directly followed by an s.
+//
+// Arguments are escaped according to where the placeholder is, as a path
+// segment before any ?, as a query value after it or as a fragment after
+// any #. Prefixing a placeholder with + substitutes the argument as is, for
+// example {+rest} to pass a path with slashes. Defaults are never escaped.
+type URLTemplate struct {
+	raw    string
+	parts  []templatePart
+	names  []string
+	maxPos int
+}
+
+// escapeBraces escapes all braces in s so they are literal braces in a URL
+// template, for URLs saved before placeholders were supported
+func escapeBraces(s string) string {
+	return strings.NewReplacer("{", "{{", "}", "}}").Replace(s)
+}
+
+// EscapeLegacyURL returns s if it is a valid URL template, otherwise s with
+// its braces escaped if that is, as URLs saved before placeholders were
+// supported may have literal braces
+func EscapeLegacyURL(s string) (string, error) {
+	_, err := ParseURLTemplate(s)
+	if err == nil {
+		return s, nil
+	}
+	escaped := escapeBraces(s)
+	if _, err := ParseURLTemplate(escaped); err != nil {
+		return s, err
+	}
+	return escaped, nil
+}
+
+// ParseURLTemplate ...
+func ParseURLTemplate(s string) (*URLTemplate, error) {
+	t := &URLTemplate{raw: s}
+
+	var (
+		literal strings.Builder
+		context urlContext
+	)
+	flush := func() {
+		if literal.Len() > 0 {
+			t.parts = append(t.parts, templatePart{kind: literalPart, literal: literal.String()})
+			literal.Reset()
+		}
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '%' && strings.HasPrefix(s[i:], "%s"):
+			flush()
+			t.parts = append(t.parts, templatePart{kind: queryPart, name: "query", context: context})
+			i++
+		case c == '%' && strings.HasPrefix(s[i:], "%%"):
+			literal.WriteByte('%')
+			i++
+		case c == '{' && strings.HasPrefix(s[i:], "{{"):
+			literal.WriteByte('{')
+			i++
+		case c == '}' && strings.HasPrefix(s[i:], "}}"):
+			literal.WriteByte('}')
+			i++
+		case c == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end == -1 {
+				return nil, invalidf("invalid url template %q: unterminated placeholder", s)
+			}
+			part, err := t.parsePlaceholder(s[i+1 : i+end])
+			if err != nil {
+				return nil, invalidf("invalid url template %q: %s", s, err)
+			}
+			part.context = context
+			flush()
+			t.parts = append(t.parts, part)
+			i += end
+		default:
+			if c == '?' && context == pathContext {
+				context = queryContext
+			} else if c == '#' {
+				context = fragmentContext
+			}
+			literal.WriteByte(c)
+		}
+	}
+	flush()
+
+	return t, nil
+}
+
+func (t *URLTemplate) parsePlaceholder(s string) (part templatePart, err error) {
+	key := s
+	if i := strings.IndexByte(s, ':'); i != -1 {
+		key, part.def, part.hasDef = s[:i], s[i+1:], true
+	}
+	if strings.HasPrefix(key, "+") {
+		key, part.raw = key[1:], true
+	}
+
+	switch {
+	case key == "rest":
+		part.kind, part.name = restPart, key
+	case key == "query":
+		part.kind, part.name = queryPart, key
+	case key != "" && strings.Trim(key, "0123456789") == "":
+		part.kind = positionalPart
+		part.pos, _ = strconv.Atoi(key)
+		if part.pos < 1 {
+			return part, fmt.Errorf("invalid placeholder {%s}: positions start at 1", s)
+		}
+	case placeholderName.MatchString(key):
+		part.kind, part.name = positionalPart, key
+		part.pos = t.namePosition(key)
+	default:
+		return part, fmt.Errorf("invalid placeholder {%s}", s)
+	}
+
+	if part.kind == positionalPart && part.pos > t.maxPos {
+		t.maxPos = part.pos
+	}
+
+	return part, nil
+}
+
+func (t *URLTemplate) namePosition(name string) int {
+	for i, n := range t.names {
+		if n == name {
+			return i + 1
+		}
+	}
+	t.names = append(t.names, name)
+	return len(t.names)
+}
+
+// String returns the template as it was parsed
+func (t *URLTemplate) String() string {
+	return t.raw
+}
+
+// Placeholders returns the labels of the placeholders in the template in
+// the order they appear
+func (t *URLTemplate) Placeholders() (labels []string) {
+	for _, part := range t.parts {
+		if part.kind != literalPart {
+			labels = append(labels, part.label())
+		}
+	}
+	return
+}
+
+// Expand substitutes the arguments in q (split on whitespace) into the
+// template. It is an error if a positional argument without a default is
+// missing.
+func (t *URLTemplate) Expand(q string) (string, error) {
+	args := strings.Fields(q)
+
+	var buf strings.Builder
+	for _, part := range t.parts {
+		var value string
+
+		switch part.kind {
+		case literalPart:
+			buf.WriteString(part.literal)
+			continue
+		case positionalPart:
+			if part.pos <= len(args) {
+				value = part.escape(args[part.pos-1])
+			} else if part.hasDef {
+				value = part.def
+			} else {
+				return "", invalidf("missing argument {%s}", part.label())
+			}
+		case restPart:
+			if t.maxPos < len(args) {
+				value = part.escape(strings.Join(args[t.maxPos:], " "))
+			} else {
+				value = part.def
+			}
+		case queryPart:
+			if q = strings.TrimSpace(q); q != "" {
+				value = part.escape(q)
+			} else {
+				value = part.def
+			}
+		}
+
+		buf.WriteString(value)
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestURLTemplateExpand(t *testing.T) {
+	assert := assert.New(t)
+
+	testCases := []struct {
+		template string
+		q        string
+		expected string
+	}{
+		{"https://example.com/", "", "https://example.com/"},
+		{"https://example.com/", "foo", "https://example.com/"},
+		{"https://example.com/?q=%s", "foo bar", "https://example.com/?q=foo+bar"},
+		{"https://example.com/?q={query}", "foo  bar", "https://example.com/?q=foo++bar"},
+		{"https://example.com/?q={query}", "", "https://example.com/?q="},
+		{"https://example.com/?q={query:home}", "", "https://example.com/?q=home"},
+		{"https://example.com/{1}/{2}", "foo bar", "https://example.com/foo/bar"},
+		{"https://example.com/{2}/{1}", "foo bar", "https://example.com/bar/foo"},
+		{"https://github.com/{owner}/{repo}", "prologic golinks", "https://github.com/prologic/golinks"},
+		{"https://github.com/{owner}/{repo}/tree/{branch:master}", "prologic golinks", "https://github.com/prologic/golinks/tree/master"},
+		{"https://example.com/{1}?q={rest}", "foo bar baz", "https://example.com/foo?q=bar+baz"},
+		{"https://example.com/{1:x}?q={rest:none}", "", "https://example.com/x?q=none"},
+		{"https://example.com/?q=define%3A+{query}", "go", "https://example.com/?q=define%3A+go"},
+		{"https://example.com/100%%s/%s", "foo", "https://example.com/100%s/foo"},
+		{"https://example.com/{{1}}/{1}", "foo", "https://example.com/{1}/foo"},
+	}
+
+	for _, tc := range testCases {
+		tmpl, err := ParseURLTemplate(tc.template)
+		assert.Nil(err, tc.template)
+
+		url, err := tmpl.Expand(tc.q)
+		assert.Nil(err, tc.template)
+		assert.Equal(tc.expected, url, tc.template)
+	}
+}
+
+func TestURLTemplateMissingArgument(t *testing.T) {
+	tmpl, err := ParseURLTemplate("https://github.com/{owner}/{repo}")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"owner", "repo"}, tmpl.Placeholders())
+
+	_, err = tmpl.Expand("prologic")
+	assert.Equal(t, "missing argument {repo}", err.Error())
+}
+
+func TestURLTemplateInvalid(t *testing.T) {
+	for _, template := range []string{
+		"https://example.com/{1",
+		"https://example.com/{0}",
+		"https://example.com/{foo bar}",
+		"https://example.com/{}",
+	} {
+		_, err := ParseURLTemplate(template)
+		assert.NotNil(t, err, template)
+		assert.IsType(t, &ValidationError{}, err)
+	}
+}
+
+func TestEscapeLegacyURL(t *testing.T) {
+	assert := assert.New(t)
+
+	s, err := EscapeLegacyURL("https://example.com/{1}?q={query}")
+	assert.Nil(err)
+	assert.Equal("https://example.com/{1}?q={query}", s)
+
+	s, err = EscapeLegacyURL(`https://example.com/?json={"q":"%s"}`)
+	assert.Nil(err)
+	assert.Equal(`https://example.com/?json={{"q":"%s"}}`, s)
+
+	s, err = EscapeLegacyURL("https://example.com/{1")
+	assert.Nil(err)
+	assert.Equal("https://example.com/{{1", s)
+}
+
+func TestValidateURL(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(ValidateURL("https://github.com/{owner}/{repo}"))
+	assert.Nil(ValidateURL("https://example.com/{3}"))
+	assert.Nil(ValidateURL("https://example.com/%s"))
+	assert.NotNil(ValidateURL("https://example.com/{1"))
+	assert.NotNil(ValidateURL("/relative/{1}"))
+	assert.NotNil(ValidateURL("{1}"))
+}
+
+func TestURLTemplateEscaping(t *testing.T) {
+	assert := assert.New(t)
+
+	testCases := []struct {
+		template string
+		q        string
+		expected string
+	}{
+		// Query values
+		{"https://example.com/?q={query}&x=1", "a&b=c", "https://example.com/?q=a%26b%3Dc&x=1"},
+		{"https://example.com/?q={query}", "c# 100%", "https://example.com/?q=c%23+100%25"},
+		{"https://example.com/?q={query}", "1+1=2", "https://example.com/?q=1%2B1%3D2"},
+		{"https://example.com/?q={query}", "a/b?c", "https://example.com/?q=a%2Fb%3Fc"},
+		{"https://example.com/?q={query}", "日本", "https://example.com/?q=%E6%97%A5%E6%9C%AC"},
+		// Path segments
+		{"https://example.com/{1}/{2}", "a/b c#d", "https://example.com/a%2Fb/c%23d"},
+		{"https://example.com/wiki/{query}", "foo bar", "https://example.com/wiki/foo%20bar"},
+		{"https://example.com/{1}?q={2}", "a?b c&d", "https://example.com/a%3Fb?q=c%26d"},
+		// Fragments
+		{"https://example.com/page#{query}", "a#b c", "https://example.com/page#a%23b%20c"},
+		{"https://example.com/?q={1}#{2}", "a#b c", "https://example.com/?q=a%23b#c"},
+		// Raw placeholders are not escaped
+		{"https://github.com/{+query}", "prologic/golinks", "https://github.com/prologic/golinks"},
+		{"https://example.com/?{+1}", "a=b&c=d", "https://example.com/?a=b&c=d"},
+		{"https://example.com/{1}/{+rest}", "a b/c d", "https://example.com/a/b/c d"},
+		// Defaults are not escaped
+		{"https://example.com/{1:a/b}", "", "https://example.com/a/b"},
+	}
+
+	for _, tc := range testCases {
+		tmpl, err := ParseURLTemplate(tc.template)
+		assert.Nil(err, tc.template)
+
+		url, err := tmpl.Expand(tc.q)
+		assert.Nil(err, tc.template)
+		assert.Equal(tc.expected, url, tc.template)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// UsagePrefix is the key prefix for usage records
+	UsagePrefix = "usage_"
+
+	// UsageBookmark is the kind of usage records for bookmarks
+	UsageBookmark = "bookmark"
+	// UsageCommand is the kind of usage records for commands
+	UsageCommand = "command"
+
+	// UsageHistoryDays is the number of days the daily histogram is kept for
+	UsageHistoryDays = 90
+
+	usageDayFormat = "2006-01-02"
+)
+
+// usageMu serializes updates of usage records, which are read, modified
+// and written back
+var usageMu sync.Mutex
+
+// Usage is the persistent record of how often and when a bookmark or
+// command was used
+type Usage struct {
+	Kind     string           `json:"kind"`
+	Name     string           `json:"name"`
+	Count    int64            `json:"count"`
+	LastUsed time.Time        `json:"last_used,omitempty"`
+	Daily    map[string]int64 `json:"daily,omitempty"`
+}
+
+// DayCount is the number of uses on a day
+type DayCount struct {
+	Day   string
+	Count int64
+}
+
+// Used reports whether the bookmark or command has ever been used
+func (u Usage) Used() bool {
+	return u.Count > 0
+}
+
+// Recent returns the daily counts for the last n days up to today, oldest
+// first, including days without any uses
+func (u Usage) Recent(n int) []DayCount {
+	today := time.Now().UTC()
+
+	days := make([]DayCount, n)
+	for i := range days {
+		day := today.AddDate(0, 0, i-n+1).Format(usageDayFormat)
+		days[i] = DayCount{Day: day, Count: u.Daily[day]}
+	}
+	return days
+}
+
+// Sparkline draws the daily counts for the last n days as a line of block
+// characters
+func (u Usage) Sparkline(n int) string {
+	const blocks = "▁▂▃▄▅▆▇█"
+	levels := []rune(blocks)
+
+	days := u.Recent(n)
+
+	var max int64
+	for _, day := range days {
+		if day.Count > max {
+			max = day.Count
+		}
+	}
+
+	var b strings.Builder
+	for _, day := range days {
+		level := 0
+		if max > 0 {
+			level = int(day.Count * int64(len(levels)-1) / max)
+		}
+		b.WriteRune(levels[level])
+	}
+	return b.String()
+}
+
+func usageKey(kind, name string) []byte {
+	return []byte(UsagePrefix + kind + "_" + strings.ToLower(name))
+}
+
+// LookupUsage returns the usage record of a bookmark or command, which is
+// empty if it was never used
+func LookupUsage(kind, name string) (Usage, error) {
+	usage := Usage{Kind: kind, Name: strings.ToLower(name)}
+
+	val, err := db.Get(usageKey(kind, name))
+	if err == ErrKeyNotFound {
+		return usage, nil
+	} else if err != nil {
+		return usage, err
+	}
+
+	if err := json.Unmarshal(val, &usage); err != nil {
+		return usage, err
+	}
+	return usage, nil
+}
+
+// RecordUsage records a use of a bookmark or command at t, dropping days
+// from the histogram older than UsageHistoryDays
+func RecordUsage(kind, name string, t time.Time) error {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	usage, err := LookupUsage(kind, name)
+	if err != nil {
+		return err
+	}
+
+	t = t.UTC()
+	usage.Count++
+	usage.LastUsed = t
+
+	if usage.Daily == nil {
+		usage.Daily = make(map[string]int64)
+	}
+	usage.Daily[t.Format(usageDayFormat)]++
+
+	cutoff := t.AddDate(0, 0, -UsageHistoryDays).Format(usageDayFormat)
+	for day := range usage.Daily {
+		if day <= cutoff {
+			delete(usage.Daily, day)
+		}
+	}
+
+	val, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	return db.Put(usageKey(kind, name), val)
+}
+
+// MergeUsageCount raises the use count of a bookmark or command to count if
+// it is lower, for counts kept elsewhere by older versions
+func MergeUsageCount(kind, name string, count int64) error {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	usage, err := LookupUsage(kind, name)
+	if err != nil {
+		return err
+	}
+	if usage.Count >= count {
+		return nil
+	}
+	usage.Count = count
+
+	val, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	return db.Put(usageKey(kind, name), val)
+}
+
+// DeleteUsage deletes the usage record of a bookmark or command
+func DeleteUsage(kind, name string) error {
+	err := db.Delete(usageKey(kind, name))
+	if err == ErrKeyNotFound {
+		return nil
+	}
+	return err
+}
+
+// ListUsage returns the usage of all bookmarks and commands, including those
+// never used, most used first
+func ListUsage() ([]Usage, error) {
+	var usages []Usage
+
+	bookmarks, err := ListBookmarks()
+	if err != nil {
+		return nil, err
+	}
+	for _, bookmark := range bookmarks {
+		usage, err := LookupUsage(UsageBookmark, bookmark.name)
+		if err != nil {
+			return nil, err
+		}
+		usages = append(usages, usage)
+	}
+
+	for name := range commands {
+		usage, err := LookupUsage(UsageCommand, name)
+		if err != nil {
+			return nil, err
+		}
+		usages = append(usages, usage)
+	}
+
+	SortUsage(usages)
+
+	return usages, nil
+}
+
+// SortUsage sorts usage records most used first, then most recently used
+// and then by name
+func SortUsage(usages []Usage) {
+	sort.Slice(usages, func(i, j int) bool {
+		a, b := usages[i], usages[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if !a.LastUsed.Equal(b.LastUsed) {
+			return a.LastUsed.After(b.LastUsed)
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Kind < b.Kind
+	})
+}
+
+func filterUsage(usages []Usage, kind string) []Usage {
+	filtered := []Usage{}
+	for _, usage := range usages {
+		if kind == "" || usage.Kind == kind {
+			filtered = append(filtered, usage)
+		}
+	}
+	return filtered
+}
+
+// UsageHandler shows the most used and never used bookmarks and commands
+func (s *Server) UsageHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		s.counters.Inc("n_usage")
+
+		usages, err := ListUsage()
+		if err != nil {
+			log.Printf("error reading usage: %s", err)
+		}
+
+		var used, unused []Usage
+		for _, usage := range usages {
+			if usage.Used() {
+				used = append(used, usage)
+			} else {
+				unused = append(unused, usage)
+			}
+		}
+
+		s.render("usage", w, map[string]interface{}{
+			"Used":   used,
+			"Unused": unused,
+		})
+	}
+}
+
+// APIListUsageHandler returns the usage of all bookmarks and commands, or
+// only those of the kind given by the kind query parameter
+func (s *Server) APIListUsageHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		s.counters.Inc("n_api_usage")
+
+		kind := r.URL.Query().Get("kind")
+		if kind != "" && kind != UsageBookmark && kind != UsageCommand {
+			writeJSONError(w, http.StatusBadRequest, "invalid kind: "+kind)
+			return
+		}
+
+		usages, err := ListUsage()
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, filterUsage(usages, kind))
+	}
+}
+
+// APIGetUsageHandler returns the usage of a single bookmark or command
+func (s *Server) APIGetUsageHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		s.counters.Inc("n_api_usage")
+
+		kind, name := p.ByName("kind"), p.ByName("name")
+		switch kind {
+		case UsageBookmark:
+			if _, ok := LookupBookmark(name); !ok {
+				writeJSONError(w, http.StatusNotFound, "bookmark not found: "+name)
+				return
+			}
+		case UsageCommand:
+			if LookupCommand(name) == nil {
+				writeJSONError(w, http.StatusNotFound, "command not found: "+name)
+				return
+			}
+		default:
+			writeJSONError(w, http.StatusNotFound, "invalid kind: "+kind)
+			return
+		}
+
+		usage, err := LookupUsage(kind, name)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, usage)
+	}
+}
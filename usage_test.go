@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordUsage(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	usage, err := LookupUsage(UsageBookmark, "wiki")
+	assert.Nil(err)
+	assert.False(usage.Used())
+	assert.Equal("wiki", usage.Name)
+
+	now := time.Now().UTC()
+	old := now.AddDate(0, 0, -UsageHistoryDays-1)
+
+	assert.Nil(RecordUsage(UsageBookmark, "wiki", old))
+	assert.Nil(RecordUsage(UsageBookmark, "Wiki", now))
+	assert.Nil(RecordUsage(UsageBookmark, "wiki", now))
+
+	usage, err = LookupUsage(UsageBookmark, "wiki")
+	assert.Nil(err)
+	assert.True(usage.Used())
+	assert.Equal(int64(3), usage.Count)
+	assert.True(now.Equal(usage.LastUsed))
+	assert.Equal(map[string]int64{now.Format("2006-01-02"): 2}, usage.Daily)
+
+	recent := usage.Recent(7)
+	assert.Len(recent, 7)
+	assert.Equal(int64(2), recent[6].Count)
+	assert.Equal(int64(0), recent[0].Count)
+	assert.Equal("▁▁▁▁▁▁█", usage.Sparkline(7))
+
+	// Commands are recorded separately from bookmarks of the same name
+	usage, err = LookupUsage(UsageCommand, "wiki")
+	assert.Nil(err)
+	assert.False(usage.Used())
+
+	assert.Nil(DeleteUsage(UsageBookmark, "wiki"))
+	usage, err = LookupUsage(UsageBookmark, "wiki")
+	assert.Nil(err)
+	assert.False(usage.Used())
+}
+
+func TestUsageRecordedOnUse(t *testing.T) {
+	assert := assert.New(t)
+
+	db = NewMemoryStore()
+	defer db.Close()
+
+	assert.Nil(SaveBookmark(NewBookmark("wiki", "https://wiki.example.com/?q={query}")))
+	assert.Nil(SaveBookmark(NewBookmark("unused", "https://example.com/")))
+
+	s := newTestServer(t, Config{})
+
+	for _, path := range []string{"/wiki/foo", "/wiki", "/ping", "/asdf"} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		s.router.ServeHTTP(w, r)
+	}
+
+	usage, err := LookupUsage(UsageBookmark, "wiki")
+	assert.Nil(err)
+	assert.Equal(int64(2), usage.Count)
+
+	usage, err = LookupUsage(UsageCommand, "ping")
+	assert.Nil(err)
+	assert.Equal(int64(1), usage.Count)
+
+	usages, err := ListUsage()
+	assert.Nil(err)
+	assert.Equal("wiki", usages[0].Name)
+	assert.Equal("ping", usages[1].Name)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/stats", nil)
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), "<code>wiki</code>")
+	assert.Contains(w.Body.String(), "Never used")
+	assert.Contains(w.Body.String(), "<code>unused</code>")
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/api/v1/usage?kind=bookmark", nil)
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+
+	var list []Usage
+	assert.Nil(json.NewDecoder(w.Body).Decode(&list))
+	assert.Len(list, 2)
+	assert.Equal("wiki", list[0].Name)
+	assert.Equal(int64(2), list[0].Count)
+	assert.Equal("unused", list[1].Name)
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/api/v1/usage/command/ping", nil)
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), `"count":1`)
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/api/v1/usage/bookmark/asdf", nil)
+	s.router.ServeHTTP(w, r)
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	// Deleted bookmarks are no longer listed but keep their usage in case
+	// they are brought back
+	assert.Nil(DeleteBookmarkBy("alice", "wiki"))
+	usages, err = ListUsage()
+	assert.Nil(err)
+	for _, usage := range usages {
+		assert.False(usage.Kind == UsageBookmark && usage.Name == "wiki")
+	}
+
+	target, _, err := UndoTarget("wiki")
+	assert.Nil(err)
+	assert.Nil(RevertBookmark("alice", ActionUndo, "wiki", target))
+	usage, err = LookupUsage(UsageBookmark, "wiki")
+	assert.Nil(err)
+	assert.Equal(int64(2), usage.Count)
+
+	// but not to a new bookmark of the same name
+	assert.Nil(DeleteBookmarkBy("alice", "wiki"))
+	assert.Nil(SaveBookmarkBy("bob", NewBookmark("wiki", "https://other.example.com/")))
+	usage, err = LookupUsage(UsageBookmark, "wiki")
+	assert.Nil(err)
+	assert.False(usage.Used())
+}